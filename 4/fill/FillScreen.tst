@@ -0,0 +1,23 @@
+// Tests the Fill program against golden images of the screen: white while no key is
+// pressed, black while a key is held, and white again once it is released.
+// Regenerate the images with screenshot in place of compare-screen.
+
+load Fill.asm;
+
+set RAM[24576] 0,    // the keyboard is untouched
+repeat 1000000 {
+  ticktock;
+}
+compare-screen FillWhite.png;
+
+set RAM[24576] 75,   // the K key is held
+repeat 1000000 {
+  ticktock;
+}
+compare-screen FillBlack.png;
+
+set RAM[24576] 0,    // the key is released
+repeat 1000000 {
+  ticktock;
+}
+compare-screen FillWhite.png;
@@ -0,0 +1,121 @@
+package assembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Directives of the extended assembly syntax placing data into RAM.
+const (
+	DATA_DIRECTIVE   = ".data"
+	TEXT_DIRECTIVE   = ".text"
+	WORD_DIRECTIVE   = ".word"
+	STRING_DIRECTIVE = ".string"
+
+	// Data is placed from the first address of variables unless .data gives another address.
+	DATA_BASE_ADDRESS   = 16
+	SCREEN_BASE_ADDRESS = 16384
+)
+
+// A word of data to be placed into RAM.
+type DataWord struct {
+	Address int
+	// The value, an operand evaluated by EvaluateWord once all symbols are known.
+	Operand string
+	// Whether the value of Operand is negated.
+	Negate bool
+	Line   SourceLine
+}
+
+// The data declared by the .data sections of a program.
+type Data struct {
+	Words []DataWord
+	// Labels of the data sections and the RAM addresses they name.
+	Symbols map[string]int
+	// The first address free for variables, after the data below the screen.
+	VariableBase int
+}
+
+// Returns the Hack assembly that stores the data into RAM, to be run before the program.
+// Every word takes 4 instructions.
+// A constant value beyond the range of an A-instruction is loaded as the bitwise complement of one within it,
+// while values with symbols must be within that range.
+func (d *Data) InitializationCode() []SourceLine {
+	var lines []SourceLine
+	for _, word := range d.Words {
+		operand, load := word.Operand, "D=A"
+		if word.Negate {
+			load = "D=-A"
+		}
+		if value, constant, err := evaluateConstantWord(word.Operand); constant && err == nil && value > MAX_A_VALUE {
+			if word.Negate {
+				value = -value
+			}
+			value &= MAX_WORD_VALUE
+			if value <= MAX_A_VALUE {
+				operand, load = strconv.Itoa(value), "D=A"
+			} else {
+				operand, load = strconv.Itoa(^value&MAX_WORD_VALUE), "D=!A"
+			}
+		}
+		for _, text := range []string{"@" + operand, load, "@" + strconv.Itoa(word.Address), "M=D"} {
+			lines = append(lines, SourceLine{Text: text, Pos: word.Line.Pos, Expansion: word.Line.Expansion})
+		}
+	}
+	return lines
+}
+
+// Evaluates operand with EvaluateWord if it is constant, without symbols.
+// Operands with symbols are only evaluated once all symbols are known.
+func evaluateConstantWord(operand string) (value int, constant bool, err error) {
+	constant = true
+	value, err = EvaluateWord(operand, func(string) int {
+		constant = false
+		return 0
+	})
+	return value, constant, err
+}
+
+// A 16-bit value at a RAM address.
+type RAMWord struct {
+	Address int
+	Value   uint16
+}
+
+// Writes a RAM image, one word per line as a decimal address followed by the 16-bit binary value.
+func WriteRAMImage(w io.Writer, words []RAMWord) error {
+	for _, word := range words {
+		if _, err := fmt.Fprintf(w, "%d %016b\n", word.Address, word.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads a RAM image written by WriteRAMImage.
+func ReadRAMImage(r io.Reader) ([]RAMWord, error) {
+	var words []RAMWord
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected an address and a value", n)
+		}
+		address, err := strconv.ParseUint(fields[0], 10, 15)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", n, fields[0])
+		}
+		value, err := strconv.ParseUint(fields[1], 2, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value %q", n, fields[1])
+		}
+		words = append(words, RAMWord{int(address), uint16(value)})
+	}
+	return words, scanner.Err()
+}
@@ -0,0 +1,40 @@
+package assembler
+
+import (
+	"strconv"
+)
+
+var (
+	destinationMnemonics = reverse(destinations)
+	computationMnemonics = reverse(computations)
+	jumpMnemonics        = reverse(jumps)
+)
+
+func reverse(codes map[string]string) map[string]string {
+	mnemonics := make(map[string]string, len(codes))
+	for mnemonic, code := range codes {
+		mnemonics[code] = mnemonic
+	}
+	return mnemonics
+}
+
+// Returns the assembly of a binary instruction, such as @17 or D=D+M;JGT.
+// C-instructions whose computation has no mnemonic are shown with their comp bits, as in D=comp(0b1001100).
+func Disassemble(instruction uint16) string {
+	if instruction&0x8000 == 0 {
+		return "@" + strconv.Itoa(int(instruction))
+	}
+	bits := func(value uint16, width int) string {
+		s := strconv.FormatUint(uint64(value), 2)
+		for len(s) < width {
+			s = "0" + s
+		}
+		return s
+	}
+	compBits := bits(instruction>>6&0x7F, 7)
+	comp, ok := computationMnemonics[compBits]
+	if !ok {
+		comp = "comp(0b" + compBits + ")"
+	}
+	return FormatC(destinationMnemonics[bits(instruction>>3&0x07, 3)], comp, jumpMnemonics[bits(instruction&0x07, 3)])
+}
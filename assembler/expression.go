@@ -0,0 +1,107 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Largest value of an A-instruction, whose most significant bit is the op-code 0.
+const MAX_A_VALUE = 1<<15 - 1
+
+// Largest value of a word of data.
+const MAX_WORD_VALUE = 1<<16 - 1
+
+// Evaluates the operand of an A-instruction in the extended syntax: a sum or difference of terms, such as SCREEN+32 or KBD-1,
+// each of which is a decimal number, a hexadecimal number like 0x4000, a binary number like 0b1010,
+// a character literal like 'A' or a symbol, whose value is given by resolve.
+// Intermediate results may leave the 15-bit range but the result must not.
+func EvaluateOperand(operand string, resolve func(symbol string) int) (int, error) {
+	return evaluate(operand, resolve, MAX_A_VALUE, "an A-instruction")
+}
+
+// Evaluates the value of a data word like EvaluateOperand, but allows the full 16-bit range, up to MAX_WORD_VALUE.
+func EvaluateWord(operand string, resolve func(symbol string) int) (int, error) {
+	return evaluate(operand, resolve, MAX_WORD_VALUE, "a word")
+}
+
+// Evaluates operand and checks that the result is within 0 to limit, the range of what.
+func evaluate(operand string, resolve func(symbol string) int, limit int, what string) (int, error) {
+	if operand == "" {
+		return 0, fmt.Errorf("missing operand")
+	}
+	total, sign := 0, 1
+	for rest := operand; ; {
+		term, value, err := nextTerm(rest, resolve)
+		if err != nil {
+			return 0, err
+		}
+		total += sign * value
+		rest = rest[len(term):]
+		if rest == "" {
+			break
+		}
+		switch rest[0] {
+		case '+':
+			sign = 1
+		case '-':
+			sign = -1
+		default:
+			return 0, fmt.Errorf("%s: unexpected %q", operand, rest[0])
+		}
+		rest = rest[1:]
+	}
+	if total < 0 || total > limit {
+		if operand != strconv.Itoa(total) {
+			operand += " = " + strconv.Itoa(total)
+		}
+		return 0, fmt.Errorf("%s is out of the range 0 to %d of %s", operand, limit, what)
+	}
+	return total, nil
+}
+
+// Returns the leading term of s and its value.
+func nextTerm(s string, resolve func(symbol string) int) (string, int, error) {
+	if s == "" {
+		return "", 0, fmt.Errorf("missing term")
+	}
+	switch {
+	case s[0] == '\'':
+		term, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid character literal %s", s)
+		}
+		char, _ := strconv.Unquote(term)
+		if len(char) != 1 {
+			return "", 0, fmt.Errorf("invalid character literal %s", term)
+		}
+		return term, int(char[0]), nil
+	case s[0] >= '0' && s[0] <= '9':
+		end := strings.IndexAny(s, "+-")
+		if end < 0 {
+			end = len(s)
+		}
+		term := s[:end]
+		digits, base := term, 10
+		if prefix := strings.ToLower(term[:min(2, len(term))]); prefix == "0x" {
+			digits, base = term[2:], 16
+		} else if prefix == "0b" {
+			digits, base = term[2:], 2
+		}
+		value, err := strconv.ParseInt(digits, base, 32)
+		if err != nil || strings.HasPrefix(digits, "+") || strings.HasPrefix(digits, "-") {
+			return "", 0, fmt.Errorf("invalid number %s", term)
+		}
+		return term, int(value), nil
+	default:
+		end := strings.IndexAny(s, "+-")
+		if end < 0 {
+			end = len(s)
+		}
+		term := s[:end]
+		if !SYMBOL_PATTERN.MatchString(term) {
+			return "", 0, fmt.Errorf("invalid symbol %q", term)
+		}
+		return term, resolve(term), nil
+	}
+}
@@ -0,0 +1,80 @@
+package assembler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Indentation of instructions in formatted assembly.
+const FORMAT_INDENT = "    "
+
+// Returns the canonical formatting of the assembly code read from input.
+// Labels are flush-left and instructions indented, C-instructions are spelled dest=comp;jump without white space,
+// comments are preserved with the indentation of the following command and runs of blank lines are collapsed.
+// Line endings are normalized to "\n".
+func Format(input io.Reader) ([]byte, error) {
+	var out bytes.Buffer
+	pendingBlank := false
+	writeLines := func(lines []string, indent string) {
+		for _, line := range lines {
+			if line == "" {
+				pendingBlank = true
+				continue
+			}
+			if pendingBlank && out.Len() > 0 {
+				out.WriteString("\n")
+			}
+			pendingBlank = false
+			out.WriteString(indent + line + "\n")
+		}
+	}
+
+	parser := NewLenientParser(input)
+	indent := ""
+	for parser.HasMoreCommands() {
+		var command string
+		switch parser.CommandType() {
+		case A_COMMAND:
+			command, indent = "@"+parser.Symbol(), FORMAT_INDENT
+		case C_COMMAND:
+			command, indent = FormatC(parser.Dest(), parser.Comp(), parser.Jump()), FORMAT_INDENT
+		case L_COMMAND:
+			command, indent = "("+parser.Symbol()+")", ""
+		default:
+			return nil, fmt.Errorf("line %d: unrecognized command %q", parser.Line(), parser.Command())
+		}
+		// Comments heading the file are not indented.
+		if out.Len() == 0 {
+			writeLines(parser.Leading(), "")
+		} else {
+			writeLines(parser.Leading(), indent)
+		}
+		if pendingBlank && out.Len() > 0 {
+			out.WriteString("\n")
+		}
+		pendingBlank = false
+		out.WriteString(indent + command)
+		if comment := parser.Comment(); comment != "" {
+			out.WriteString(" " + comment)
+		}
+		out.WriteString("\n")
+		parser.Advance()
+	}
+	writeLines(parser.Leading(), indent)
+	return out.Bytes(), nil
+}
+
+// Returns the C-instruction with the given mnemonics spelled as dest=comp;jump, omitting empty fields.
+func FormatC(dest, comp, jump string) string {
+	var b strings.Builder
+	if dest != "" {
+		b.WriteString(dest + "=")
+	}
+	b.WriteString(comp)
+	if jump != "" {
+		b.WriteString(";" + jump)
+	}
+	return b.String()
+}
@@ -0,0 +1,43 @@
+package assembler
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFormatIdempotent checks that formatting the assembly programs of the repository again changes nothing.
+func TestFormatIdempotent(t *testing.T) {
+	var files []string
+	filepath.WalkDir("..", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".asm") {
+			files = append(files, path)
+		}
+		return err
+	})
+	if len(files) == 0 {
+		t.Fatal("no .asm files found")
+	}
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		once, err := Format(bytes.NewReader(source))
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		twice, err := Format(bytes.NewReader(once))
+		if err != nil {
+			t.Errorf("%s: formatting the formatted code: %v", file, err)
+			continue
+		}
+		if !bytes.Equal(once, twice) {
+			t.Errorf("%s: formatting the formatted code changes it:\n%s\nthen:\n%s", file, once, twice)
+		}
+	}
+}
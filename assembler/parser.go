@@ -5,6 +5,7 @@ import (
 	"io"
 	"regexp"
 	"strings"
+	"unicode"
 )
 
 type CommandType string
@@ -24,7 +25,7 @@ const (
 
 var (
 	A_COMMAND_PATTERN = regexp.MustCompile(`^@.+$`)
-	C_COMMAND_PATTERN = regexp.MustCompile(`^(?P<dest>D|A|M|AD|AM|MD)?=?(?P<comp>0|1|-1|D|A|!D|!A|-D|-A|D\+1|A\+1|D-1|A-1|D\+A|D-A|A-D|D&A|D\|A|M|!M|-M|M\+1|M-1|D\+M|D-M|M-D|D&M|D\|M);?(?P<jump>JGT|JEQ|JGE|JLT|JNE|JLE|JMP)?[ ]*(\/\/.*)?$`)
+	C_COMMAND_PATTERN = regexp.MustCompile(`^(?:(?P<dest>AMD|AD|AM|MD|D|A|M)=)?(?P<comp>0|1|-1|D|A|!D|!A|-D|-A|D\+1|A\+1|D-1|A-1|D\+A|D-A|A-D|D&A|D\|A|M|!M|-M|M\+1|M-1|D\+M|D-M|M-D|D&M|D\|M);?(?P<jump>JGT|JEQ|JGE|JLT|JNE|JLE|JMP)?[ ]*(\/\/.*)?$`)
 	L_COMMAND_PATTERN = regexp.MustCompile(`^\((?P<variable>.*)\)$`)
 )
 
@@ -36,14 +37,32 @@ type Parser struct {
 	scanner        *bufio.Scanner
 	moreCommands   bool
 	currentCommand string
+	line           int
+	currentLine    int
+	comment        string
+	leading        []string
+	// lenient ignores white space within commands.
+	lenient bool
 }
 
-// Opens the input file/stream and gets ready to parse it.
+// Opens the input file/stream and gets ready to parse it in the course syntax,
+// where commands hold no white space, e.g. "D = M" is not recognized.
 func NewParser(input io.Reader) *Parser {
+	return newParser(input, false)
+}
+
+// Opens the input file/stream like NewParser, but ignores white space within commands, e.g. "D = M" is "D=M",
+// except in character literals like ' '. The formatter and the extended syntax parse commands this way.
+func NewLenientParser(input io.Reader) *Parser {
+	return newParser(input, true)
+}
+
+func newParser(input io.Reader, lenient bool) *Parser {
 	p := &Parser{
 		scanner:        bufio.NewScanner(input),
 		moreCommands:   true,
 		currentCommand: "",
+		lenient:        lenient,
 	}
 	p.Advance()
 	return p
@@ -61,16 +80,71 @@ func (p *Parser) Advance() {
 	if !p.moreCommands {
 		return
 	}
-	moreCommands := p.scanner.Scan()
-	if !moreCommands {
-		p.moreCommands = false
+	p.leading, p.comment = nil, ""
+	for p.scanner.Scan() {
+		p.line++
+		text := strings.TrimSpace(p.scanner.Text())
+		code, comment, hasComment := strings.Cut(text, COMMENT_PREFIX)
+		if p.lenient {
+			code = removeSpaces(code)
+		} else {
+			code = strings.TrimSpace(code)
+		}
+		if len(code) == 0 {
+			p.leading = append(p.leading, text)
+			continue
+		}
+		p.currentCommand, p.currentLine = code, p.line
+		if hasComment {
+			p.comment = COMMENT_PREFIX + comment
+		}
+		return
 	}
-	p.currentCommand = strings.TrimSpace(p.scanner.Text())
-	if len(p.currentCommand) == 0 {
-		p.Advance()
-	} else if strings.HasPrefix(p.currentCommand, COMMENT_PREFIX) {
-		p.Advance()
+	p.moreCommands = false
+	p.currentCommand = ""
+}
+
+// Returns s without the white space outside character literals such as ' ' or '\”.
+func removeSpaces(s string) string {
+	var b strings.Builder
+	inLiteral := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inLiteral && c == '\\' && i+1 < len(s):
+			b.WriteByte(c)
+			i++
+			c = s[i]
+		case c == '\'':
+			inLiteral = !inLiteral
+		case !inLiteral && unicode.IsSpace(rune(c)):
+			continue
+		}
+		b.WriteByte(c)
 	}
+	return b.String()
+}
+
+// Returns the current command without white space and comments.
+func (p *Parser) Command() string {
+	return p.currentCommand
+}
+
+// Returns the 1-based line number of the current command in the input.
+func (p *Parser) Line() int {
+	return p.currentLine
+}
+
+// Returns the comment at the end of the current command's line, including its leading "//", if any.
+func (p *Parser) Comment() string {
+	return p.comment
+}
+
+// Returns the comment and blank lines found between the previous command and the current one,
+// with surrounding white space removed.
+// Once hasMoreCommands() is false, returns the lines following the last command.
+func (p *Parser) Leading() []string {
+	return p.leading
 }
 
 // Returns the type of the current command.
@@ -0,0 +1,69 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParserCourseSyntax(t *testing.T) {
+	tests := []struct {
+		line, want  string
+		commandType CommandType
+	}{
+		{"  D=M+1  // increment", "D=M+1", C_COMMAND},
+		{"\t@LOOP\t// loop", "@LOOP", A_COMMAND},
+		{"(LOOP)", "(LOOP)", L_COMMAND},
+		{"  D = M + 1  // increment", "D = M + 1", UNRECOGNIZED_COMMAND},
+		{"AM =M-1", "AM =M-1", UNRECOGNIZED_COMMAND},
+		{"@ LOOP", "@ LOOP", A_COMMAND},
+	}
+	for _, test := range tests {
+		p := NewParser(strings.NewReader(test.line + "\n"))
+		if got := p.Command(); got != test.want {
+			t.Errorf("command of %q = %q, want %q", test.line, got, test.want)
+		}
+		if got := p.CommandType(); got != test.commandType {
+			t.Errorf("type of %q = %s, want %s", test.line, got, test.commandType)
+		}
+	}
+}
+
+func TestLenientParserWhiteSpace(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"  D = M + 1  // increment", "D=M+1"},
+		{"AM = M - 1", "AM=M-1"},
+		{"@ LOOP", "@LOOP"},
+		{"@' '", "@' '"},
+		{"@ ' ' + 1", "@' '+1"},
+		{`@'\''`, `@'\''`},
+		{`@'\\' + ' '`, `@'\\'+' '`},
+	}
+	for _, test := range tests {
+		p := NewLenientParser(strings.NewReader(test.line + "\n"))
+		if got := p.Command(); got != test.want {
+			t.Errorf("command of %q = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestSplitArguments(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"", nil},
+		{"1", []string{"1"}},
+		{" a , b ", []string{"a", "b"}},
+		{"' ', 'x'", []string{"' '", "'x'"}},
+		{"',', ' , '", []string{"','", "' , '"}},
+		{`'\'', ','`, []string{`'\''`, "','"}},
+	}
+	for _, test := range tests {
+		got := splitArguments(test.list)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") || len(got) != len(test.want) {
+			t.Errorf("splitArguments(%q) = %q, want %q", test.list, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,469 @@
+package assembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Directives of the extended assembly syntax, expanded by the Preprocessor before assembly.
+const (
+	INCLUDE_DIRECTIVE  = ".include"
+	DEFINE_DIRECTIVE   = ".define"
+	MACRO_DIRECTIVE    = ".macro"
+	ENDMACRO_DIRECTIVE = ".endm"
+
+	// Within a macro body, \name is replaced by the argument for the parameter name
+	// and \@ by a number unique to the expansion, for making labels local to it.
+	MACRO_PARAMETER_PREFIX = `\`
+	MACRO_UNIQUE           = `\@`
+
+	maxExpansionDepth = 64
+)
+
+var (
+	SYMBOL_PATTERN          = regexp.MustCompile(`^[A-Za-z_.$:][A-Za-z0-9_.$:]*$`)
+	MACRO_PARAMETER_PATTERN = regexp.MustCompile(`\\([A-Za-z_][A-Za-z0-9_]*|@)`)
+	// Character literals, numbers and symbols of A-instruction operands, in which definitions are substituted for symbols.
+	OPERAND_TOKEN_PATTERN = regexp.MustCompile(`'(?:\\.|[^'])*'|[0-9][A-Za-z0-9_]*|[A-Za-z_.$:][A-Za-z0-9_.$:]*`)
+)
+
+// Locates a line of assembly source.
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	return p.File + ":" + strconv.Itoa(p.Line)
+}
+
+// A line of assembly produced by the Preprocessor, with its origin.
+type SourceLine struct {
+	Text string
+	Pos  Position
+	// The macro invocations the line was expanded from, innermost first.
+	Expansion []Position
+}
+
+// Reports a problem in assembly source.
+type Error struct {
+	Pos       Position
+	Expansion []Position
+	Message   string
+}
+
+func (e *Error) Error() string {
+	s := e.Pos.String() + ": " + e.Message
+	for _, invocation := range e.Expansion {
+		s += "\n\tin macro expansion at " + invocation.String()
+	}
+	return s
+}
+
+type macro struct {
+	name       string
+	parameters []string
+	body       []SourceLine
+}
+
+// Expands the directives of the extended assembly syntax into plain Hack assembly:
+//
+//	.include "file.asm"        inserts the lines of file.asm, relative to the including file
+//	.define NAME value         replaces the symbol NAME by value in the A-instructions that follow
+//	.macro NAME a, b ... .endm defines a macro, invoked as NAME x, y
+//	.data [address]            starts a data section, placed at address or after the previous data
+//	.word v, ...               places 16-bit values into RAM, each an A-instruction operand optionally negated
+//	.string "text"             places the characters of text into RAM, followed by 0
+//	.text                      ends a data section
+//
+// Labels (NAME) within a data section name the RAM address of the data that follows; see Data.
+// It also expands pseudo-instructions such as LOAD D, X or JMP LOOP; see PseudoInstructionUsage.
+// Macros take precedence over pseudo-instructions of the same name.
+type Preprocessor struct {
+	// Opens included files. Defaults to os.Open.
+	Open func(name string) (io.ReadCloser, error)
+
+	defines     map[string]string
+	macros      map[string]*macro
+	including   []string
+	expansions  int
+	inData      bool
+	dataAddress int
+	data        Data
+}
+
+// Creates a preprocessor with no definitions.
+func NewPreprocessor() *Preprocessor {
+	return &Preprocessor{
+		Open: func(name string) (io.ReadCloser, error) {
+			return os.Open(name)
+		},
+		defines:     make(map[string]string),
+		macros:      make(map[string]*macro),
+		dataAddress: DATA_BASE_ADDRESS,
+		data:        Data{Symbols: make(map[string]int), VariableBase: DATA_BASE_ADDRESS},
+	}
+}
+
+// Returns the data declared by the preprocessed files.
+func (pp *Preprocessor) Data() *Data {
+	return &pp.data
+}
+
+// Returns the expanded lines of the named assembly file read from input, without comments and blank lines.
+func (pp *Preprocessor) Preprocess(filename string, input io.Reader) ([]SourceLine, error) {
+	lines, err := readSourceLines(filename, input)
+	if err != nil {
+		return nil, err
+	}
+	pp.including = append(pp.including, filename)
+	defer func() { pp.including = pp.including[:len(pp.including)-1] }()
+
+	var out []SourceLine
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		fields := strings.Fields(line.Text)
+		switch fields[0] {
+		case INCLUDE_DIRECTIVE:
+			included, err := pp.include(line, fields)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, included...)
+		case DEFINE_DIRECTIVE:
+			if err := pp.define(line, fields); err != nil {
+				return nil, err
+			}
+		case MACRO_DIRECTIVE:
+			end := i + 1
+			for end < len(lines) && strings.Fields(lines[end].Text)[0] != ENDMACRO_DIRECTIVE {
+				end++
+			}
+			if end == len(lines) {
+				return nil, lineError(line, "%s without %s", MACRO_DIRECTIVE, ENDMACRO_DIRECTIVE)
+			}
+			if err := pp.defineMacro(line, lines[i+1:end]); err != nil {
+				return nil, err
+			}
+			i = end
+		case ENDMACRO_DIRECTIVE:
+			return nil, lineError(line, "%s without %s", ENDMACRO_DIRECTIVE, MACRO_DIRECTIVE)
+		case DATA_DIRECTIVE, TEXT_DIRECTIVE, WORD_DIRECTIVE, STRING_DIRECTIVE:
+			if err := pp.dataDirective(line, fields); err != nil {
+				return nil, err
+			}
+		default:
+			if pp.inData {
+				if err := pp.dataLabel(line); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			expanded, err := pp.expand(line, 0)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, expanded...)
+		}
+	}
+	return out, nil
+}
+
+// Reads the commands of an assembly file, dropping comments and blank lines.
+func readSourceLines(filename string, input io.Reader) ([]SourceLine, error) {
+	var lines []SourceLine
+	scanner := bufio.NewScanner(input)
+	for n := 1; scanner.Scan(); n++ {
+		if code := strings.TrimSpace(stripComment(scanner.Text())); code != "" {
+			lines = append(lines, SourceLine{Text: code, Pos: Position{filename, n}})
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Removes the comment from a line, except within string and character literals.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0 && line[i] == '\\':
+			i++
+		case quote != 0 && line[i] == quote:
+			quote = 0
+		case quote == 0 && (line[i] == '"' || line[i] == '\''):
+			quote = line[i]
+		case quote == 0 && strings.HasPrefix(line[i:], COMMENT_PREFIX):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func (pp *Preprocessor) include(line SourceLine, fields []string) ([]SourceLine, error) {
+	name, err := strconv.Unquote(strings.TrimSpace(strings.TrimPrefix(line.Text, INCLUDE_DIRECTIVE)))
+	if len(fields) < 2 || err != nil {
+		return nil, lineError(line, `usage: %s "file.asm"`, INCLUDE_DIRECTIVE)
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(line.Pos.File), name)
+	}
+	for _, including := range pp.including {
+		if including == name {
+			return nil, lineError(line, "%s includes itself", name)
+		}
+	}
+	f, err := pp.Open(name)
+	if err != nil {
+		return nil, lineError(line, "%v", err)
+	}
+	defer f.Close()
+	return pp.Preprocess(name, f)
+}
+
+func (pp *Preprocessor) define(line SourceLine, fields []string) error {
+	if len(fields) != 3 || !SYMBOL_PATTERN.MatchString(fields[1]) {
+		return lineError(line, "usage: %s NAME value", DEFINE_DIRECTIVE)
+	}
+	if _, defined := pp.defines[fields[1]]; defined {
+		return lineError(line, "%s is already defined", fields[1])
+	}
+	pp.defines[fields[1]] = fields[2]
+	return nil
+}
+
+func (pp *Preprocessor) defineMacro(line SourceLine, body []SourceLine) error {
+	header := strings.TrimSpace(strings.TrimPrefix(line.Text, MACRO_DIRECTIVE))
+	name, parameterList := cutField(header)
+	if !SYMBOL_PATTERN.MatchString(name) {
+		return lineError(line, "usage: %s NAME param, ...", MACRO_DIRECTIVE)
+	}
+	if _, defined := pp.macros[name]; defined {
+		return lineError(line, "macro %s is already defined", name)
+	}
+	m := &macro{name: name, body: body}
+	for _, parameter := range splitArguments(parameterList) {
+		if reference := MACRO_PARAMETER_PREFIX + parameter; MACRO_PARAMETER_PATTERN.FindString(reference) != reference || reference == MACRO_UNIQUE {
+			return lineError(line, "invalid macro parameter %q", parameter)
+		}
+		m.parameters = append(m.parameters, parameter)
+	}
+	for _, bodyLine := range body {
+		if directive := strings.Fields(bodyLine.Text)[0]; strings.HasPrefix(directive, ".") {
+			return lineError(bodyLine, "%s is not allowed in a macro body", directive)
+		}
+	}
+	pp.macros[name] = m
+	return nil
+}
+
+// Substitutes definitions for the symbols of an A-instruction operand.
+func (pp *Preprocessor) substitute(operand string) string {
+	return OPERAND_TOKEN_PATTERN.ReplaceAllStringFunc(operand, func(token string) string {
+		if value, defined := pp.defines[token]; defined {
+			return value
+		}
+		return token
+	})
+}
+
+// Expands a line invoking a macro into the macro body, recursively, and substitutes definitions into other lines.
+func (pp *Preprocessor) expand(line SourceLine, depth int) ([]SourceLine, error) {
+	name, argumentList := cutField(line.Text)
+	m, isMacro := pp.macros[name]
+	if pseudo, isPseudo := pseudoInstructions[name]; isPseudo && !isMacro {
+		return pp.expandPseudoInstruction(line, name, pseudo, splitArguments(argumentList), depth)
+	}
+	if !isMacro {
+		if operand, isA := strings.CutPrefix(line.Text, "@"); isA {
+			line.Text = "@" + pp.substitute(operand)
+		}
+		return []SourceLine{line}, nil
+	}
+	if depth == maxExpansionDepth {
+		return nil, lineError(line, "macro %s: expansion nested too deeply", name)
+	}
+	arguments := splitArguments(argumentList)
+	if len(arguments) != len(m.parameters) {
+		return nil, lineError(line, "macro %s takes %d arguments, got %d", name, len(m.parameters), len(arguments))
+	}
+	pp.expansions++
+	unique := strconv.Itoa(pp.expansions)
+	var out []SourceLine
+	for _, bodyLine := range m.body {
+		var err error
+		text := MACRO_PARAMETER_PATTERN.ReplaceAllStringFunc(bodyLine.Text, func(reference string) string {
+			if reference == MACRO_UNIQUE {
+				return unique
+			}
+			for i, parameter := range m.parameters {
+				if reference == MACRO_PARAMETER_PREFIX+parameter {
+					return arguments[i]
+				}
+			}
+			err = lineError(bodyLine, "macro %s has no parameter %s", name, reference[1:])
+			return reference
+		})
+		if err != nil {
+			return nil, err
+		}
+		expanded, err := pp.expand(SourceLine{
+			Text:      text,
+			Pos:       bodyLine.Pos,
+			Expansion: append([]Position{line.Pos}, line.Expansion...),
+		}, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+	}
+	return out, nil
+}
+
+func (pp *Preprocessor) expandPseudoInstruction(line SourceLine, name string, pseudo pseudoInstruction, arguments []string, depth int) ([]SourceLine, error) {
+	if !slices.Contains(pseudo.arity, len(arguments)) {
+		return nil, lineError(line, "usage: %s", pseudo.usage)
+	}
+	pp.expansions++
+	texts, err := pseudo.expand(arguments, pp.expansions)
+	if err != nil {
+		return nil, lineError(line, "%s: %v", name, err)
+	}
+	var out []SourceLine
+	for _, text := range texts {
+		// Expanded again so that definitions apply to the addressed symbols.
+		expanded, err := pp.expand(SourceLine{Text: text, Pos: line.Pos, Expansion: line.Expansion}, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+	}
+	return out, nil
+}
+
+// Splits text into its first field and the rest.
+func cutField(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		return text[:i], strings.TrimSpace(text[i:])
+	}
+	return text, ""
+}
+
+// Splits a comma separated list of macro arguments or parameters.
+func splitArguments(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+	// Commas in character literals such as ',' do not separate arguments.
+	var arguments []string
+	start, inLiteral := 0, false
+	for i := 0; i < len(list); i++ {
+		switch {
+		case inLiteral && list[i] == '\\':
+			i++
+		case list[i] == '\'':
+			inLiteral = !inLiteral
+		case !inLiteral && list[i] == ',':
+			arguments = append(arguments, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+	return append(arguments, strings.TrimSpace(list[start:]))
+}
+
+func lineError(line SourceLine, format string, args ...any) *Error {
+	return &Error{Pos: line.Pos, Expansion: line.Expansion, Message: fmt.Sprintf(format, args...)}
+}
+
+func (pp *Preprocessor) dataDirective(line SourceLine, fields []string) error {
+	directive, arguments := cutField(line.Text)
+	switch directive {
+	case DATA_DIRECTIVE:
+		pp.inData = true
+		if arguments == "" {
+			return nil
+		}
+		var symbols []string
+		address, err := EvaluateOperand(pp.substitute(removeSpaces(arguments)), func(symbol string) int {
+			symbols = append(symbols, symbol)
+			return 0
+		})
+		if len(symbols) > 0 {
+			return lineError(line, "%s: address must be constant, but %s is a symbol", DATA_DIRECTIVE, symbols[0])
+		}
+		if err != nil {
+			return lineError(line, "%s: %v", DATA_DIRECTIVE, err)
+		}
+		pp.dataAddress = address
+		return nil
+	case TEXT_DIRECTIVE:
+		if len(fields) != 1 {
+			return lineError(line, "usage: %s", TEXT_DIRECTIVE)
+		}
+		pp.inData = false
+		return nil
+	}
+	if !pp.inData {
+		return lineError(line, "%s outside of a %s section", directive, DATA_DIRECTIVE)
+	}
+	var operands []string
+	switch directive {
+	case WORD_DIRECTIVE:
+		operands = splitArguments(arguments)
+		if len(operands) == 0 {
+			return lineError(line, "usage: %s value, ...", WORD_DIRECTIVE)
+		}
+	case STRING_DIRECTIVE:
+		text, err := strconv.Unquote(arguments)
+		if err != nil || !strings.HasPrefix(arguments, `"`) {
+			return lineError(line, `usage: %s "text"`, STRING_DIRECTIVE)
+		}
+		for i := 0; i < len(text); i++ {
+			if text[i] >= 0x80 {
+				return lineError(line, "%s: %q is not an ASCII character", STRING_DIRECTIVE, text[i])
+			}
+			operands = append(operands, strconv.Itoa(int(text[i])))
+		}
+		operands = append(operands, "0")
+	}
+	for _, operand := range operands {
+		if pp.dataAddress > MAX_A_VALUE {
+			return lineError(line, "data beyond the end of RAM")
+		}
+		word := DataWord{Address: pp.dataAddress, Line: line}
+		operand = removeSpaces(operand)
+		operand, word.Negate = strings.CutPrefix(operand, "-")
+		word.Operand = pp.substitute(operand)
+		if directive == WORD_DIRECTIVE {
+			if _, constant, err := evaluateConstantWord(word.Operand); constant && err != nil {
+				return lineError(line, "%s: %v", WORD_DIRECTIVE, err)
+			}
+		}
+		pp.data.Words = append(pp.data.Words, word)
+		if pp.dataAddress < SCREEN_BASE_ADDRESS {
+			pp.data.VariableBase = max(pp.data.VariableBase, pp.dataAddress+1)
+		}
+		pp.dataAddress++
+	}
+	return nil
+}
+
+func (pp *Preprocessor) dataLabel(line SourceLine) error {
+	label, opened := strings.CutPrefix(line.Text, "(")
+	label, closed := strings.CutSuffix(label, ")")
+	if !opened || !closed || !SYMBOL_PATTERN.MatchString(label) {
+		return lineError(line, "only labels and data directives are allowed in a %s section", DATA_DIRECTIVE)
+	}
+	if _, defined := pp.data.Symbols[label]; defined {
+		return lineError(line, "data label %s is already defined", label)
+	}
+	pp.data.Symbols[label] = pp.dataAddress
+	return nil
+}
@@ -0,0 +1,89 @@
+package assembler
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Expands the arguments of a pseudo-instruction into Hack assembly.
+// unique is a number unique to the expansion, for making labels local to it.
+type pseudoInstruction struct {
+	usage  string
+	arity  []int
+	expand func(args []string, unique int) ([]string, error)
+}
+
+var conditionalJumps = map[string]string{"JEQ": "D = 0", "JNE": "D != 0", "JGT": "D > 0", "JGE": "D >= 0", "JLT": "D < 0", "JLE": "D <= 0"}
+
+// The pseudo-instructions of the extended assembly syntax, by name.
+// Registers are A, D or M, and X is a symbol or number addressed with an A-instruction.
+var pseudoInstructions = map[string]pseudoInstruction{
+	"LOAD": {"LOAD r, X loads RAM[X] into the registers r", []int{2}, func(args []string, _ int) ([]string, error) {
+		if _, ok := destinations[args[0]]; !ok || args[0] == "" {
+			return nil, fmt.Errorf("invalid destination %q", args[0])
+		}
+		return []string{"@" + args[1], args[0] + "=M"}, nil
+	}},
+	"SET": {"SET r, X loads the value X into the registers r", []int{2}, func(args []string, _ int) ([]string, error) {
+		if _, ok := destinations[args[0]]; !ok || args[0] == "" {
+			return nil, fmt.Errorf("invalid destination %q", args[0])
+		}
+		return []string{"@" + args[1], args[0] + "=A"}, nil
+	}},
+	"STORE": {"STORE X, v stores v, one of D, 0, 1 or -1, into RAM[X]", []int{2}, func(args []string, _ int) ([]string, error) {
+		if !slices.Contains([]string{"D", "0", "1", "-1"}, args[1]) {
+			return nil, fmt.Errorf("invalid value %q", args[1])
+		}
+		return []string{"@" + args[0], "M=" + args[1]}, nil
+	}},
+	"INC": {"INC r[, X] increments the register r, addressing RAM[X] first if given", []int{1, 2}, func(args []string, _ int) ([]string, error) {
+		return incrementBy(args, "+1")
+	}},
+	"DEC": {"DEC r[, X] decrements the register r, addressing RAM[X] first if given", []int{1, 2}, func(args []string, _ int) ([]string, error) {
+		return incrementBy(args, "-1")
+	}},
+	"JMP": {"JMP L jumps to L", []int{1}, func(args []string, _ int) ([]string, error) {
+		return []string{"@" + args[0], "0;JMP"}, nil
+	}},
+	"PUSHD": {"PUSHD pushes D onto the stack at SP", []int{0}, func([]string, int) ([]string, error) {
+		return []string{"@SP", "AM=M+1", "A=A-1", "M=D"}, nil
+	}},
+	"POPD": {"POPD pops the top of the stack at SP into D", []int{0}, func([]string, int) ([]string, error) {
+		return []string{"@SP", "AM=M-1", "D=M"}, nil
+	}},
+	"HALT": {"HALT loops forever", []int{0}, func(_ []string, unique int) ([]string, error) {
+		label := "HALT$" + strconv.Itoa(unique)
+		return []string{"(" + label + ")", "@" + label, "0;JMP"}, nil
+	}},
+}
+
+func init() {
+	for jump, condition := range conditionalJumps {
+		pseudoInstructions[jump] = pseudoInstruction{jump + " L jumps to L if " + condition, []int{1}, func(args []string, _ int) ([]string, error) {
+			return []string{"@" + args[0], "D;" + jump}, nil
+		}}
+	}
+}
+
+func incrementBy(args []string, operation string) ([]string, error) {
+	register := args[0]
+	if !slices.Contains([]string{"A", "D", "M"}, register) {
+		return nil, fmt.Errorf("invalid register %q", register)
+	}
+	var lines []string
+	if len(args) == 2 {
+		lines = append(lines, "@"+args[1])
+	}
+	return append(lines, register+"="+register+operation), nil
+}
+
+// Returns the usage of every pseudo-instruction, in alphabetical order.
+func PseudoInstructionUsage() []string {
+	var usage []string
+	for _, p := range pseudoInstructions {
+		usage = append(usage, p.usage)
+	}
+	slices.Sort(usage)
+	return usage
+}
@@ -0,0 +1,92 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+// expand preprocesses source and returns the text of the resulting lines.
+func expand(source string) ([]string, error) {
+	lines, err := NewPreprocessor().Preprocess("pseudo.asm", strings.NewReader(source))
+	var texts []string
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+	}
+	return texts, err
+}
+
+func TestPseudoInstructions(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{"LOAD D, x", []string{"@x", "D=M"}},
+		{"LOAD AM, 5", []string{"@5", "AM=M"}},
+		{"SET D, 100", []string{"@100", "D=A"}},
+		{"SET AMD, SCREEN", []string{"@SCREEN", "AMD=A"}},
+		{"STORE x, D", []string{"@x", "M=D"}},
+		{"STORE R0, 0", []string{"@R0", "M=0"}},
+		{"STORE R1, 1", []string{"@R1", "M=1"}},
+		{"STORE x, -1", []string{"@x", "M=-1"}},
+		{"INC D", []string{"D=D+1"}},
+		{"INC M, counter", []string{"@counter", "M=M+1"}},
+		{"DEC A", []string{"A=A-1"}},
+		{"DEC M, i", []string{"@i", "M=M-1"}},
+		{"JMP LOOP", []string{"@LOOP", "0;JMP"}},
+		{"PUSHD", []string{"@SP", "AM=M+1", "A=A-1", "M=D"}},
+		{"POPD", []string{"@SP", "AM=M-1", "D=M"}},
+		{"HALT", []string{"(HALT$1)", "@HALT$1", "0;JMP"}},
+		{"HALT\nHALT", []string{"(HALT$1)", "@HALT$1", "0;JMP", "(HALT$2)", "@HALT$2", "0;JMP"}},
+		{".define LIMIT 10\nSET D, LIMIT\nJMP LIMIT", []string{"@10", "D=A", "@10", "0;JMP"}},
+	}
+	for jump := range conditionalJumps {
+		tests = append(tests, struct {
+			source string
+			want   []string
+		}{jump + " END", []string{"@END", "D;" + jump}})
+	}
+	for _, test := range tests {
+		got, err := expand(test.source + "\n")
+		if err != nil {
+			t.Errorf("%q: %v", test.source, err)
+			continue
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%q expands to %q, want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestPseudoInstructionErrors(t *testing.T) {
+	tests := []struct {
+		source, want string
+	}{
+		{"LOAD D", "pseudo.asm:1: usage: LOAD r, X"},
+		{"LOAD D, x, y", "pseudo.asm:1: usage: LOAD r, X"},
+		{"LOAD Q, x", `pseudo.asm:1: LOAD: invalid destination "Q"`},
+		{"LOAD , x", `pseudo.asm:1: LOAD: invalid destination ""`},
+		{"SET", "pseudo.asm:1: usage: SET r, X"},
+		{"SET X, 1", `pseudo.asm:1: SET: invalid destination "X"`},
+		{"STORE x", "pseudo.asm:1: usage: STORE X, v"},
+		{"STORE x, 2", `pseudo.asm:1: STORE: invalid value "2"`},
+		{"STORE x, A", `pseudo.asm:1: STORE: invalid value "A"`},
+		{"INC", "pseudo.asm:1: usage: INC r[, X]"},
+		{"INC Q", `pseudo.asm:1: INC: invalid register "Q"`},
+		{"INC AD", `pseudo.asm:1: INC: invalid register "AD"`},
+		{"DEC D, x, y", "pseudo.asm:1: usage: DEC r[, X]"},
+		{"DEC Q, x", `pseudo.asm:1: DEC: invalid register "Q"`},
+		{"JMP", "pseudo.asm:1: usage: JMP L"},
+		{"JMP a, b", "pseudo.asm:1: usage: JMP L"},
+		{"JEQ", "pseudo.asm:1: usage: JEQ L"},
+		{"JLT a, b", "pseudo.asm:1: usage: JLT L"},
+		{"PUSHD D", "pseudo.asm:1: usage: PUSHD"},
+		{"POPD D", "pseudo.asm:1: usage: POPD"},
+		{"@0\nHALT now", "pseudo.asm:2: usage: HALT"},
+	}
+	for _, test := range tests {
+		_, err := expand(test.source + "\n")
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%q: %v, want %s", test.source, err, test.want)
+		}
+	}
+}
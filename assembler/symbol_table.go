@@ -1,13 +1,16 @@
 package assembler
 
+import "slices"
+
 // Keeps a correspondence between symbolic labels and numeric addresses.
 type SymbolTable struct {
 	symbols map[string]int
+	labels  map[string]bool
 }
 
 // Creates a new empty symbol table.
 func NewSymbolTable() *SymbolTable {
-	return &SymbolTable{make(map[string]int)}
+	return &SymbolTable{symbols: make(map[string]int), labels: make(map[string]bool)}
 }
 
 // Adds the pair (symbol, address) to the table.
@@ -25,3 +28,24 @@ func (st *SymbolTable) Contains(symbol string) bool {
 func (st *SymbolTable) GetAddress(symbol string) int {
 	return st.symbols[symbol]
 }
+
+// Adds the pair (symbol, address) to the table, where symbol is a label naming a ROM address.
+func (st *SymbolTable) AddLabel(symbol string, address int) {
+	st.AddEntry(symbol, address)
+	st.labels[symbol] = true
+}
+
+// Is the symbol a label naming a ROM address, rather than a RAM address?
+func (st *SymbolTable) IsLabel(symbol string) bool {
+	return st.labels[symbol]
+}
+
+// Returns the symbols of the table in alphabetical order.
+func (st *SymbolTable) Symbols() []string {
+	symbols := make([]string, 0, len(st.symbols))
+	for symbol := range st.symbols {
+		symbols = append(symbols, symbol)
+	}
+	slices.Sort(symbols)
+	return symbols
+}
@@ -0,0 +1,43 @@
+package command
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/benjaminclauss/nand2tetris/assembler"
+)
+
+func NewAsmFmtCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "asmfmt [.asm file]...",
+		Short: "Formatter for Hack assembly programs",
+		Long: `
+The assembly formatter rewrites .asm files in canonical form: labels flush-left, instructions
+indented, C-instructions spelled dest=comp;jump without white space, comments preserved
+and blank lines collapsed.
+
+By default the formatted code is written to standard output. With --write, files are
+rewritten in place. With --check, nothing is written; the files that are not formatted
+are listed and the command fails if there are any.
+	`,
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return formatFiles(cmd, args, formatAssembly)
+		},
+	}
+	addFormatFlags(cmd)
+
+	return cmd
+}
+
+// formatAssembly formats an assembly program, naming filename in errors.
+func formatAssembly(filename string, r io.Reader) ([]byte, error) {
+	formatted, err := assembler.Format(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return formatted, nil
+}
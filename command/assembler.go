@@ -2,6 +2,7 @@ package command
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,69 +14,207 @@ import (
 	"github.com/benjaminclauss/nand2tetris/assembler"
 )
 
-var assemblerCmd = &cobra.Command{
-	Use:   "assembler [.asm file]",
-	Short: "Assembler for Hack programs",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		inputFilename := args[0]
-		inputFile, err := os.Open(inputFilename)
-		if err != nil {
-			return err
-		}
-		defer inputFile.Close()
-		outputFilename := fmt.Sprintf("%s.hack", strings.TrimSuffix(inputFilename, ".asm"))
-		outputFile, err := os.Create(outputFilename)
-		if err != nil {
-			return err
-		}
-		defer outputFile.Close()
-		err = Assemble(inputFile, outputFile)
-		if err != nil {
-			return err
-		}
-		return outputFile.Sync()
-	},
+// Placements of the data of extended assembly programs, accepted by the assembler --data flag.
+const (
+	dataInit = "init"
+	dataRAM  = "ram"
+)
+
+func NewAssemblerCommand() *cobra.Command {
+	var extended bool
+	var dataPlacement string
+	cmd := &cobra.Command{
+		Use:   "assembler [.asm file]",
+		Short: "Assembler for Hack programs",
+		Long: `
+The assembler translates a Hack assembly program Xxx.asm into binary code written to Xxx.hack.
+
+By default, only the assembly language of the course is accepted. With --extended, the
+following directives are expanded before assembly:
+
+  .include "file.asm"          inserts file.asm, relative to the including file
+  .define NAME value           replaces the symbol NAME with value in the A-instructions
+                               that follow
+  .macro NAME a, b ... .endm   defines a macro invoked as NAME x, y, whose body refers
+                               to its parameters as \a and \b, and to a number unique
+                               to each expansion as \@
+  .data [address]              starts a data section at address, by default after the
+                               previous data or at 16, in which labels (NAME) name RAM
+                               addresses and variables are allocated after the data
+  .word v, ...                 places 16-bit values, A-instruction operands or their negation
+  .string "text"               places the characters of text followed by 0
+  .text                        ends a data section
+
+Data is placed into RAM by initialization code run before the program or, with
+--data=ram, written to a Xxx.ram image of address and binary value lines for an
+emulator to preload, as with emulate --ram.
+Initialization code loads a value with symbols through an A-instruction, so it must
+be within 0 to 32767; constant values and RAM images take the full 16-bit range.
+
+A-instruction operands may also be sums and differences such as @SCREEN+32 or @KBD-1 of
+symbols, decimal numbers, hexadecimal numbers like @0x4000, binary numbers like @0b1010
+and character literals like @'A', evaluated at assembly time. White space within commands
+is ignored, so D = M + 1 is D=M+1, which the course syntax rejects.
+
+The following pseudo-instructions expand into standard Hack instructions:
+
+  ` + strings.Join(assembler.PseudoInstructionUsage(), "\n  ") + `
+	`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inputFilename := args[0]
+			inputFile, err := os.Open(inputFilename)
+			if err != nil {
+				return err
+			}
+			defer inputFile.Close()
+			if dataPlacement != dataInit && dataPlacement != dataRAM {
+				return fmt.Errorf("invalid --data %q: must be init or ram", dataPlacement)
+			}
+			outputFilename := fmt.Sprintf("%s.hack", strings.TrimSuffix(inputFilename, ".asm"))
+			outputFile, err := os.Create(outputFilename)
+			if err != nil {
+				return err
+			}
+			defer outputFile.Close()
+			if extended {
+				var ramImage io.Writer
+				if dataPlacement == dataRAM {
+					ramFile, err := os.Create(strings.TrimSuffix(inputFilename, ".asm") + ".ram")
+					if err != nil {
+						return err
+					}
+					defer ramFile.Close()
+					ramImage = ramFile
+				}
+				_, err = AssembleExtended(inputFilename, inputFile, outputFile, ramImage)
+			} else {
+				_, err = Assemble(inputFile, outputFile)
+			}
+			// Errors in the extended syntax already name the file they occurred in.
+			if sourceError := (*assembler.Error)(nil); errors.As(err, &sourceError) {
+				return err
+			} else if err != nil {
+				return fmt.Errorf("%s: %w", inputFilename, err)
+			}
+			return outputFile.Sync()
+		},
+	}
+	cmd.Flags().BoolVarP(&extended, "extended", "x", false, "accept the extended assembly syntax")
+	cmd.Flags().StringVar(&dataPlacement, "data", dataInit, "place extended .data into RAM with initialization code (init) or a Xxx.ram image (ram)")
+
+	return cmd
 }
 
-func Assemble(input io.Reader, output io.Writer) error {
+// Assemble translates the Hack assembly read from input into binary code written to output.
+// It returns the symbol table of the program, with its labels, variables and predefined symbols.
+func Assemble(input io.Reader, output io.Writer) (*assembler.SymbolTable, error) {
+	return assemble(input, output, nil)
+}
+
+// AssembleExtended expands the extended syntax of the named assembly file read from input,
+// then assembles it like Assemble. Errors point at the original file and line, through macro expansions.
+// Data is written to ramImage if it is not nil, or else initialized by code placed before the program.
+func AssembleExtended(filename string, input io.Reader, output, ramImage io.Writer) (*assembler.SymbolTable, error) {
+	pp := assembler.NewPreprocessor()
+	lines, err := pp.Preprocess(filename, input)
+	if err != nil {
+		return nil, err
+	}
+	if ramImage == nil {
+		lines = append(pp.Data().InitializationCode(), lines...)
+	}
+	var text strings.Builder
+	for _, line := range lines {
+		text.WriteString(line.Text + "\n")
+	}
+	return assemble(strings.NewReader(text.String()), output, &extension{lines: lines, data: pp.Data(), ramImage: ramImage})
+}
+
+// extension holds what assemble needs for programs in the extended syntax.
+type extension struct {
+	// lines gives the origin of each line of the preprocessed input.
+	lines    []assembler.SourceLine
+	data     *assembler.Data
+	ramImage io.Writer
+}
+
+// assemble performs the two passes of the assembler.
+// For programs in the extended syntax, ext is not nil and A-instruction operands are evaluated as expressions.
+func assemble(input io.Reader, output io.Writer, ext *extension) (*assembler.SymbolTable, error) {
+	errorIn := func(line assembler.SourceLine, format string, args ...any) error {
+		return &assembler.Error{Pos: line.Pos, Expansion: line.Expansion, Message: fmt.Sprintf(format, args...)}
+	}
+	errorAt := func(line int, format string, args ...any) error {
+		if ext != nil {
+			return errorIn(ext.lines[line-1], format, args...)
+		}
+		return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+	}
+
 	var buf bytes.Buffer
 	tee := io.TeeReader(input, &buf)
 
 	st := assembler.NewSymbolTable()
 	initializeSymbolTable(st)
-	firstPassParser := assembler.NewParser(tee)
+	nextAvailableRAMAddress := 16
+	if ext != nil {
+		for symbol, address := range ext.data.Symbols {
+			if st.Contains(symbol) {
+				return nil, fmt.Errorf("data label %s is a predefined symbol", symbol)
+			}
+			st.AddEntry(symbol, address)
+		}
+		nextAvailableRAMAddress = ext.data.VariableBase
+	}
+	// The extended syntax, unlike the course syntax, allows white space within commands.
+	newParser := assembler.NewParser
+	if ext != nil {
+		newParser = assembler.NewLenientParser
+	}
+	firstPassParser := newParser(tee)
 	currentROMAddress := 0
 	for firstPassParser.HasMoreCommands() {
 		switch firstPassParser.CommandType() {
 		case assembler.L_COMMAND:
 			symbol := firstPassParser.Symbol()
-			st.AddEntry(symbol, currentROMAddress)
+			if _, isData := ext.dataSymbol(symbol); isData {
+				return nil, errorAt(firstPassParser.Line(), "label %s is already a data label", symbol)
+			}
+			st.AddLabel(symbol, currentROMAddress)
 		case assembler.C_COMMAND, assembler.A_COMMAND:
 			currentROMAddress++
+		default:
+			return nil, errorAt(firstPassParser.Line(), "unrecognized command %q", firstPassParser.Command())
 		}
 		firstPassParser.Advance()
 	}
 
-	secondPassParser := assembler.NewParser(&buf)
-	nextAvailableRAMAddress := 16
+	secondPassParser := newParser(&buf)
+	// Symbols that are neither predefined nor labels are variables, allocated RAM from address 16 on first use.
+	resolveSymbol := func(symbol string) int {
+		if !st.Contains(symbol) {
+			st.AddEntry(symbol, nextAvailableRAMAddress)
+			nextAvailableRAMAddress++
+		}
+		return st.GetAddress(symbol)
+	}
 
 	for secondPassParser.HasMoreCommands() {
 		switch secondPassParser.CommandType() {
 		case assembler.A_COMMAND:
-			symbol := secondPassParser.Symbol()
-			if number, err := strconv.Atoi(symbol); err == nil {
-				io.WriteString(output, fmt.Sprintf("0%015b\n", number))
+			var value int
+			var err error
+			if ext != nil {
+				value, err = assembler.EvaluateOperand(secondPassParser.Symbol(), resolveSymbol)
 			} else {
-				if st.Contains(symbol) {
-					address := st.GetAddress(symbol)
-					io.WriteString(output, fmt.Sprintf("0%015b\n", address))
-				} else {
-					st.AddEntry(symbol, nextAvailableRAMAddress)
-					io.WriteString(output, fmt.Sprintf("0%015b\n", nextAvailableRAMAddress))
-					nextAvailableRAMAddress++
-				}
+				value, err = resolveA(secondPassParser.Symbol(), resolveSymbol)
 			}
+			if err != nil {
+				return nil, errorAt(secondPassParser.Line(), "%v", err)
+			}
+			io.WriteString(output, fmt.Sprintf("0%015b\n", value))
 		case assembler.C_COMMAND:
 			comp := assembler.Comp(secondPassParser.Comp())
 			dest := assembler.Dest(secondPassParser.Dest())
@@ -84,7 +223,45 @@ func Assemble(input io.Reader, output io.Writer) error {
 		}
 		secondPassParser.Advance()
 	}
-	return nil
+
+	if ext == nil || ext.ramImage == nil {
+		return st, nil
+	}
+	var words []assembler.RAMWord
+	for _, word := range ext.data.Words {
+		value, err := assembler.EvaluateWord(word.Operand, resolveSymbol)
+		if err != nil {
+			return nil, errorIn(word.Line, "%v", err)
+		}
+		if word.Negate {
+			value = -value
+		}
+		words = append(words, assembler.RAMWord{Address: word.Address, Value: uint16(value)})
+	}
+	return st, assembler.WriteRAMImage(ext.ramImage, words)
+}
+
+// dataSymbol returns the RAM address named by a data label of the program.
+func (ext *extension) dataSymbol(symbol string) (int, bool) {
+	if ext == nil {
+		return 0, false
+	}
+	address, isData := ext.data.Symbols[symbol]
+	return address, isData
+}
+
+// resolveA returns the value of the operand Xxx of an A-instruction @Xxx in the course syntax, a decimal number or a symbol.
+func resolveA(operand string, resolveSymbol func(string) int) (int, error) {
+	if number, err := strconv.Atoi(operand); err == nil {
+		if number < 0 || number > assembler.MAX_A_VALUE {
+			return 0, fmt.Errorf("%d is out of the range 0 to %d of an A-instruction", number, assembler.MAX_A_VALUE)
+		}
+		return number, nil
+	}
+	if !assembler.SYMBOL_PATTERN.MatchString(operand) {
+		return 0, fmt.Errorf("invalid symbol %q", operand)
+	}
+	return resolveSymbol(operand), nil
 }
 
 // Initialize the symbol table with all the predefined symbols and their pre-allocated RAM addresses.
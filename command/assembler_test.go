@@ -0,0 +1,217 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	source := `
+// Adds 1 to the variable x until it is 0.
+(LOOP)
+   @x
+   AMD=M+1   // all three destinations
+   @LOOP
+   D;JNE
+   @32767
+   0;JMP
+`
+	want := strings.Join([]string{
+		"0000000000010000",
+		"1111110111111000",
+		"0000000000000000",
+		"1110001100000101",
+		"0111111111111111",
+		"1110101010000111",
+	}, "\n") + "\n"
+	var output bytes.Buffer
+	st, err := Assemble(strings.NewReader(source), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != want {
+		t.Errorf("Assemble wrote\n%s\nwant\n%s", output.String(), want)
+	}
+	if !st.IsLabel("LOOP") || st.GetAddress("LOOP") != 0 {
+		t.Errorf("LOOP is not the label of address 0")
+	}
+	if st.IsLabel("x") || st.GetAddress("x") != 16 {
+		t.Errorf("x is not the variable at address 16")
+	}
+}
+
+// The course syntax is checked since the extended syntax was added: these inputs used to be
+// silently ignored or assembled into meaningless code.
+func TestAssembleRejects(t *testing.T) {
+	tests := []struct {
+		name, source, wantErr string
+	}{
+		{"unrecognized command", "@1\nfoo bar\n", "line 2: unrecognized command"},
+		{"unknown computation", "D=Q\n", "line 1: unrecognized command"},
+		{"destination without =", "DM\n", "line 1: unrecognized command"},
+		{"unknown jump", "0;JUMP\n", "line 1: unrecognized command"},
+		{"unclosed label", "(LOOP\n", "line 1: unrecognized command"},
+		{"negative address", "@-1\n", "line 1: -1 is out of the range"},
+		{"address too large", "@0\n@32768\n", "line 2: 32768 is out of the range"},
+		{"symbol starting with a digit", "@1abc\n", `line 1: invalid symbol "1abc"`},
+		{"symbol with an operator", "@x+1\n", `line 1: invalid symbol "x+1"`},
+		{"spaces within a C-instruction", "D = M\n", `line 1: unrecognized command "D = M"`},
+		{"space after @", "@ 5\n", `line 1: invalid symbol " 5"`},
+	}
+	for _, test := range tests {
+		_, err := Assemble(strings.NewReader(test.source), &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: Assemble(%q) error = %v, want %q", test.name, test.source, err, test.wantErr)
+		}
+	}
+}
+
+func TestAssembleCharacterLiterals(t *testing.T) {
+	source := `
+@' '
+D=A
+@ 'A'
+@'\''
+.data 100
+.word ' ', 'x', ','
+.text
+`
+	var output, ram bytes.Buffer
+	if _, err := AssembleExtended("literals.asm", strings.NewReader(source), &output, &ram); err != nil {
+		t.Fatal(err)
+	}
+	wantOutput := "0000000000100000\n1110110000010000\n0000000001000001\n0000000000100111\n"
+	if output.String() != wantOutput {
+		t.Errorf("AssembleExtended wrote\n%s\nwant\n%s", output.String(), wantOutput)
+	}
+	wantRAM := "100 0000000000100000\n101 0000000001111000\n102 0000000000101100\n"
+	if ram.String() != wantRAM {
+		t.Errorf("AssembleExtended wrote the RAM image\n%s\nwant\n%s", ram.String(), wantRAM)
+	}
+}
+
+// TestRAMImageRoundTrip assembles data into a RAM image, then preloads it into an emulator running the program.
+func TestRAMImageRoundTrip(t *testing.T) {
+	source := `
+.data 100
+(numbers)
+.word 7, -1, 'x'
+(greeting)
+.string "Hi"
+.text
+	@greeting
+	D=M
+	@R0
+	M=D
+(END)
+	@END
+	0;JMP
+`
+	dir := t.TempDir()
+	hackFile, ramFile := filepath.Join(dir, "Data.hack"), filepath.Join(dir, "Data.ram")
+	var hack, ram bytes.Buffer
+	if _, err := AssembleExtended("Data.asm", strings.NewReader(source), &hack, &ram); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(hackFile, hack.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ramFile, ram.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	program, err := loadProgram(hackFile, loadOptions{ramImage: ramFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	computer := program.newComputer()
+	want := map[int]uint16{100: 7, 101: 0xFFFF, 102: 'x', 103: 'H', 104: 'i', 105: 0}
+	check := func(when string) {
+		for address, value := range want {
+			if got := computer.RAM[address]; got != value {
+				t.Errorf("%s: RAM[%d] = %d, want %d", when, address, got, value)
+			}
+		}
+	}
+	check("before running")
+	if !computer.Run(100) {
+		t.Fatal("the program did not halt")
+	}
+	if computer.RAM[0] != 'H' {
+		t.Errorf("the program read RAM[103] = %d, want %d", computer.RAM[0], 'H')
+	}
+	computer.Reset()
+	check("after a reset")
+}
+
+// TestDataWords checks that data words take the full 16-bit range, both in a RAM image and through initialization code.
+func TestDataWords(t *testing.T) {
+	source := `
+.data 100
+.word 0xFFFF, 0x8000, 40000, -0xFFFF, -0x8000, -1, 32767, SCREEN-1
+.text
+(END)
+	@END
+	0;JMP
+`
+	want := []uint16{0xFFFF, 0x8000, 40000, 1, 0x8000, 0xFFFF, 32767, 16383}
+	dir := t.TempDir()
+	for _, ramImage := range []bool{false, true} {
+		hackFile, ramFile := filepath.Join(dir, "Data.hack"), filepath.Join(dir, "Data.ram")
+		var hack, ram bytes.Buffer
+		var opts loadOptions
+		if ramImage {
+			opts.ramImage = ramFile
+			if _, err := AssembleExtended("Data.asm", strings.NewReader(source), &hack, &ram); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(ramFile, ram.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		} else if _, err := AssembleExtended("Data.asm", strings.NewReader(source), &hack, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(hackFile, hack.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		program, err := loadProgram(hackFile, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		computer := program.newComputer()
+		if !computer.Run(1000) {
+			t.Fatalf("with a RAM image %v, the program did not halt", ramImage)
+		}
+		for i, value := range want {
+			if got := computer.RAM[100+i]; got != value {
+				t.Errorf("with a RAM image %v, RAM[%d] = %d, want %d", ramImage, 100+i, got, value)
+			}
+		}
+	}
+
+	for _, ram := range []io.Writer{nil, &bytes.Buffer{}} {
+		_, err := AssembleExtended("Data.asm", strings.NewReader(".data\n.word 0x10000\n.text\n"), &bytes.Buffer{}, ram)
+		if wantErr := "0x10000 = 65536 is out of the range 0 to 65535 of a word"; err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("AssembleExtended(.word 0x10000) error = %v, want %q", err, wantErr)
+		}
+	}
+}
+
+// TestAssembleExtendedWhiteSpace checks that the extended syntax, unlike the course syntax, allows spaces within commands.
+func TestAssembleExtendedWhiteSpace(t *testing.T) {
+	var course, extended bytes.Buffer
+	if _, err := Assemble(strings.NewReader("@5\nAMD=M+1\n0;JMP\n"), &course); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AssembleExtended("spaces.asm", strings.NewReader("@ 5\nAMD = M + 1\n0 ; JMP\n"), &extended, nil); err != nil {
+		t.Fatal(err)
+	}
+	if extended.String() != course.String() {
+		t.Errorf("AssembleExtended wrote\n%s\nwant\n%s", extended.String(), course.String())
+	}
+}
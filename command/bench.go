@@ -0,0 +1,96 @@
+package command
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+// DefaultBenchmarkCycles is long enough for the timing of the emulator to be stable.
+const DefaultBenchmarkCycles = 50_000_000
+
+func NewBenchCommand() *cobra.Command {
+	var opts loadOptions
+	var cycles uint64
+	var keyScript string
+	cmd := &cobra.Command{
+		Use:   "bench <.asm or .hack file>",
+		Short: "Benchmarks the emulator on a Hack program",
+		Long: `
+The benchmark runs a Hack program for --cycles instructions, or until it halts, twice: once
+stepping one instruction at a time as the debugger and tracer do, and once with the predecoded
+core used by emulate and run. It reports the throughput of both in millions of instructions per
+second, and fails if they do not end in the same state.
+
+A program such as 6/test/pong/Pong.asm, which never halts, measures sustained throughput.
+With --keys, a keyboard script is replayed into the keyboard of both runs.
+	`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			program, err := loadProgram(args[0], opts)
+			if err != nil {
+				return err
+			}
+			var events []emulator.KeyEvent
+			if keyScript != "" {
+				if events, err = readKeyScript(keyScript); err != nil {
+					return err
+				}
+			}
+			newComputer := func() *emulator.Computer {
+				computer := program.newComputer()
+				if events != nil {
+					computer.Input = emulator.NewKeyboardInput(events)
+				}
+				return computer
+			}
+
+			stepped := newComputer()
+			elapsed := measure(func() {
+				for range cycles {
+					if stepped.Halted() {
+						break
+					}
+					stepped.Step()
+				}
+			})
+			reportThroughput(cmd, "stepped", stepped.Cycle, elapsed, 0)
+
+			predecoded := newComputer()
+			predecodedElapsed := measure(func() { predecoded.Run(cycles) })
+			reportThroughput(cmd, "predecoded", predecoded.Cycle, predecodedElapsed, elapsed)
+
+			if stepped.A != predecoded.A || stepped.D != predecoded.D || stepped.PC != predecoded.PC ||
+				stepped.Cycle != predecoded.Cycle || !slices.Equal(stepped.RAM, predecoded.RAM) {
+				return fmt.Errorf("the stepped and predecoded runs ended in different states")
+			}
+			return nil
+		},
+	}
+	addLoadFlags(cmd, &opts)
+	cmd.Flags().Uint64Var(&cycles, "cycles", DefaultBenchmarkCycles, "number of instructions to execute")
+	cmd.Flags().StringVar(&keyScript, "keys", "", "keyboard script replayed into the keyboard memory map")
+
+	return cmd
+}
+
+func measure(run func()) time.Duration {
+	start := time.Now()
+	run()
+	return time.Since(start)
+}
+
+// reportThroughput prints the instructions executed per second, and the speedup over a baseline if not 0.
+func reportThroughput(cmd *cobra.Command, name string, instructions uint64, elapsed, baseline time.Duration) {
+	mips := float64(instructions) / max(elapsed.Seconds(), 1e-9) / 1e6
+	fmt.Fprintf(cmd.OutOrStdout(), "%-10s  %d instructions in %v: %.1f MIPS", name, instructions, elapsed.Round(time.Millisecond), mips)
+	if baseline != 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), " (%.1fx)", baseline.Seconds()/max(elapsed.Seconds(), 1e-9))
+	}
+	fmt.Fprintln(cmd.OutOrStdout())
+}
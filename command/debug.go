@@ -0,0 +1,153 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/benjaminclauss/nand2tetris/assembler"
+	"github.com/benjaminclauss/nand2tetris/debugger"
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+func NewDebugCommand() *cobra.Command {
+	var opts loadOptions
+	var history int
+	cmd := &cobra.Command{
+		Use:   "debug <.asm or .hack file>",
+		Short: "Interactive debugger for Hack programs",
+		Long: `
+The debugger runs a Hack program in an emulator of the Hack computer, reading commands from
+standard input. Assembly programs are assembled first, so that labels and variables can be
+used in place of ROM and RAM addresses; binary programs are debugged with addresses only.
+
+Breakpoints stop before executing the instruction at a label or ROM address, and
+watchpoints stop after an instruction writes a variable or RAM address. Programs are
+considered halted on reaching the infinite loop that conventionally ends them.
+
+The last --history instructions executed are recorded, so that the program can run backwards:
+reverse-step undoes instructions, reverse-continue runs back to a breakpoint or watchpoint,
+last-write runs back to the instruction that last wrote a RAM address, and origin shows that
+instruction and its cycle without moving. The history grows as instructions execute, so a
+large --history costs memory only once that many have run, and --history 0 records none.
+Type help for the list of commands.
+	`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			program, err := loadProgram(args[0], opts)
+			if err != nil {
+				return err
+			}
+			computer := program.newComputer()
+			computer.History = emulator.NewHistory(history)
+			d := debugger.New(computer, program.symbols, cmd.OutOrStdout())
+			d.Execute("list")
+			input := bufio.NewScanner(cmd.InOrStdin())
+			for {
+				fmt.Fprint(cmd.OutOrStdout(), "(hack) ")
+				if !input.Scan() {
+					fmt.Fprintln(cmd.OutOrStdout())
+					return input.Err()
+				}
+				if d.Execute(input.Text()) {
+					return nil
+				}
+			}
+		},
+	}
+	addLoadFlags(cmd, &opts)
+	cmd.Flags().IntVar(&history, "history", debugger.DefaultHistorySize, "number of instructions recorded for reverse execution")
+
+	return cmd
+}
+
+// loadOptions control how loadProgram reads programs.
+type loadOptions struct {
+	// extended accepts the extended assembly syntax.
+	extended bool
+	// ramImage names a RAM image to preload, as written by the assembler with --data=ram.
+	ramImage string
+}
+
+// addLoadFlags adds the flags of the commands loading programs with loadProgram.
+func addLoadFlags(cmd *cobra.Command, opts *loadOptions) {
+	cmd.Flags().BoolVarP(&opts.extended, "extended", "x", false, "accept the extended assembly syntax")
+	cmd.Flags().StringVar(&opts.ramImage, "ram", "", "preload this RAM image, as written by assembler --data=ram")
+}
+
+// A program read by loadProgram.
+type loadedProgram struct {
+	code []uint16
+	// symbols is the symbol table of assembly programs, and empty for binary programs.
+	symbols *assembler.SymbolTable
+	// ram holds the words of the RAM image to preload, if any.
+	ram []assembler.RAMWord
+}
+
+// newComputer creates a computer with the program in its ROM and the RAM image preloaded.
+func (p *loadedProgram) newComputer() *emulator.Computer {
+	computer := emulator.NewComputer(p.code)
+	computer.Preload(p.ram)
+	return computer
+}
+
+// loadProgram reads the binary code of a .hack file, or assembles a .asm file and keeps its symbol table as well,
+// then reads the RAM image to preload, if any.
+func loadProgram(filename string, opts loadOptions) (*loadedProgram, error) {
+	code, symbols, err := readProgram(filename, opts.extended)
+	if err != nil {
+		return nil, err
+	}
+	p := &loadedProgram{code: code, symbols: symbols}
+	if opts.ramImage != "" {
+		f, err := os.Open(opts.ramImage)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if p.ram, err = assembler.ReadRAMImage(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", opts.ramImage, err)
+		}
+	}
+	return p, nil
+}
+
+// readProgram reads the binary code of a .hack file, or assembles a .asm file and returns its symbol table as well.
+func readProgram(filename string, extended bool) ([]uint16, *assembler.SymbolTable, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	if filepath.Ext(filename) != ".asm" {
+		program, err := emulator.ReadProgram(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		return program, assembler.NewSymbolTable(), nil
+	}
+
+	var binary bytes.Buffer
+	var symbols *assembler.SymbolTable
+	if extended {
+		symbols, err = AssembleExtended(filename, file, &binary, nil)
+	} else {
+		symbols, err = Assemble(file, &binary)
+	}
+	if sourceError := (*assembler.Error)(nil); errors.As(err, &sourceError) {
+		return nil, nil, err
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	program, err := emulator.ReadProgram(&binary)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return program, symbols, nil
+}
@@ -0,0 +1,409 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/benjaminclauss/nand2tetris/assembler"
+	"github.com/benjaminclauss/nand2tetris/emulator"
+	"github.com/benjaminclauss/nand2tetris/profile"
+	vm "github.com/benjaminclauss/nand2tetris/virtualmachine"
+)
+
+// DefaultEmulationCycles bounds headless emulation of programs that never halt.
+const DefaultEmulationCycles = 100_000_000
+
+func NewEmulateCommand() *cobra.Command {
+	var opts loadOptions
+	var cycles uint64
+	var screenshot, compare, screenStyle string
+	var scale int
+	var assignments []string
+	var keyScript string
+	var traceFilename string
+	var traceFilter emulator.TraceFilter
+	var tracePC, traceWrites []string
+	var profileFilename, foldedFilename, sourceMapFilename string
+	var resumeFilename, snapshotFilename, stopAt string
+	cmd := &cobra.Command{
+		Use:   "emulate <.asm or .hack file>",
+		Short: "Headless emulator for Hack programs",
+		Long: `
+The emulator runs a Hack program without display until it halts, that is reaches the infinite
+loop that conventionally ends Hack programs, or until it has executed --cycles instructions.
+
+With --keys, a keyboard script is replayed into the keyboard memory map. Each line holds the
+cycle at which a key is pressed, the key and optionally the number of cycles it is held, as in
+"1000000 left 50000"; keys are Hack character codes or names such as a, space, left, F1 or none.
+
+With --trace, the PC, instruction, A, D, writeM, addressM and outM of every cycle are
+written to a .csv file, or to a .vcd waveform file viewable in GTKWave. The cycles traced can be
+restricted to the window from --trace-from to --trace-to, to the instructions at the ROM
+addresses given by --trace-pc and to those writing the RAM addresses given by --trace-writes.
+Addresses are given as ranges such as 100-200, numbers, labels for the instructions up to the
+next label, or variables.
+
+With --profile, a flat profile of the cycles spent in each function is written, "-" standing
+for standard output: the cycles spent in the function itself, those including the functions it
+calls, and the number of calls. With --folded, the cycles spent in each call stack are written
+in the folded format read by flame graph tools such as flamegraph.pl or speedscope. Programs
+translated from VM code are profiled by VM function using the source map given by --source-map,
+which defaults to the Xxx.map written next to Xxx.asm by vmtranslator --annotate. Other programs
+are profiled by label, counting as calls the times execution enters the code of a label.
+
+With --snapshot, the state of the machine at the end of the run is saved to a file, from which a
+later run resumes with --resume, which fails unless the same program is loaded. Combined with
+--cycles, this saves the state at a given cycle; with --stop-at, the run stops before executing
+the instruction at a label or ROM address, as a breakpoint. Snapshots hold the keyboard state,
+the whole key script and the next event to replay, so --keys cannot be used with --resume.
+
+Registers and RAM can be initialized with --set, as in --set R0=100 or --set 16384=-1, and
+with --ram, which preloads the Xxx.ram image of the data written by assembler -x --data=ram.
+The screen memory map can then be saved as a 512x256 PNG image with --screenshot, drawn on
+standard output with --screen=braille or --screen=blocks, and compared with a golden image
+with --compare, which fails if any pixel differs.
+	`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			program, err := loadProgram(args[0], opts)
+			if err != nil {
+				return err
+			}
+			symbols := program.symbols
+			computer := program.newComputer()
+			if resumeFilename != "" {
+				if keyScript != "" {
+					return fmt.Errorf("--keys cannot be used with --resume, the snapshot holds the keyboard state")
+				}
+				if err := restoreSnapshot(computer, resumeFilename); err != nil {
+					return err
+				}
+			}
+			if keyScript != "" {
+				events, err := readKeyScript(keyScript)
+				if err != nil {
+					return err
+				}
+				computer.Input = emulator.NewKeyboardInput(events)
+			}
+			for _, assignment := range assignments {
+				if err := setRAM(computer, symbols, assignment); err != nil {
+					return err
+				}
+			}
+			var observers []func(emulator.TraceRecord) error
+			var profiler *profile.Profiler
+			if profileFilename != "" || foldedFilename != "" {
+				if profiler, err = newProfiler(args[0], sourceMapFilename, symbols); err != nil {
+					return err
+				}
+				observers = append(observers, profiler.Observe)
+			}
+			var traceFile *os.File
+			var traceWriter emulator.TraceWriter
+			if traceFilename != "" {
+				for _, spec := range tracePC {
+					r, err := addressRange(spec, symbols, true)
+					if err != nil {
+						return fmt.Errorf("invalid --trace-pc: %w", err)
+					}
+					traceFilter.PC = append(traceFilter.PC, r)
+				}
+				for _, spec := range traceWrites {
+					r, err := addressRange(spec, symbols, false)
+					if err != nil {
+						return fmt.Errorf("invalid --trace-writes: %w", err)
+					}
+					traceFilter.Writes = append(traceFilter.Writes, r)
+				}
+				if traceFile, traceWriter, err = createTrace(traceFilename); err != nil {
+					return err
+				}
+				defer traceFile.Close()
+				observers = append(observers, emulator.Trace(traceWriter, &traceFilter))
+				// Without a profile to complete, the run ends with the cycles traced.
+				if profiler == nil && traceFilter.To != 0 {
+					cycles = min(cycles, traceFilter.To-min(traceFilter.To, computer.Cycle))
+				}
+			}
+			stopped := false
+			if stopAt != "" {
+				r, err := addressRange(stopAt, symbols, true)
+				if err != nil {
+					return fmt.Errorf("invalid --stop-at: %w", err)
+				}
+				observers = append(observers, func(emulator.TraceRecord) error {
+					if computer.PC == r.First {
+						return errStopped
+					}
+					return nil
+				})
+			}
+			halted := false
+			switch len(observers) {
+			case 0:
+				halted = computer.Run(cycles)
+			case 1:
+				halted, err = computer.RunObserved(cycles, observers[0])
+			default:
+				halted, err = computer.RunObserved(cycles, func(record emulator.TraceRecord) error {
+					for _, observe := range observers {
+						if err := observe(record); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			}
+			if errors.Is(err, errStopped) {
+				stopped, err = true, nil
+			}
+			if err != nil {
+				return err
+			}
+			if traceWriter != nil {
+				if err := traceWriter.Flush(); err != nil {
+					return err
+				}
+				if err := traceFile.Close(); err != nil {
+					return err
+				}
+			}
+			if stopped {
+				fmt.Fprintf(cmd.ErrOrStderr(), "stopped at ROM[%d] after %d cycles\n", computer.PC, computer.Cycle)
+			} else if !halted {
+				fmt.Fprintf(cmd.ErrOrStderr(), "stopped after %d cycles without halting\n", computer.Cycle)
+			}
+			if snapshotFilename != "" {
+				if err := saveSnapshot(computer, snapshotFilename); err != nil {
+					return err
+				}
+			}
+			if profileFilename != "" {
+				if err := writeProfile(cmd, profileFilename, profiler.WriteFlat); err != nil {
+					return err
+				}
+			}
+			if foldedFilename != "" {
+				if err := writeProfile(cmd, foldedFilename, profiler.WriteFolded); err != nil {
+					return err
+				}
+			}
+			if screenshot != "" {
+				if err := writeScreenshot(screenshot, computer.RAM); err != nil {
+					return err
+				}
+			}
+			if screenStyle != "" {
+				if err := emulator.RenderScreen(cmd.OutOrStdout(), computer.RAM, screenStyle, scale); err != nil {
+					return err
+				}
+			}
+			if compare != "" {
+				return compareScreen(compare, computer.RAM)
+			}
+			return nil
+		},
+	}
+	addLoadFlags(cmd, &opts)
+	cmd.Flags().StringArrayVar(&assignments, "set", nil, "set RAM[ADDRESS] or a predefined symbol or variable before running, as ADDRESS=VALUE")
+	cmd.Flags().StringVar(&keyScript, "keys", "", "keyboard script replayed into the keyboard memory map")
+	cmd.Flags().StringVar(&traceFilename, "trace", "", "write a trace of the cycles to this .csv or .vcd file")
+	cmd.Flags().Uint64Var(&traceFilter.From, "trace-from", 0, "first cycle traced")
+	cmd.Flags().Uint64Var(&traceFilter.To, "trace-to", 0, "cycle at which tracing stops, if not 0")
+	cmd.Flags().StringArrayVar(&tracePC, "trace-pc", nil, "trace only the instructions at these ROM addresses")
+	cmd.Flags().StringArrayVar(&traceWrites, "trace-writes", nil, "trace only the instructions writing these RAM addresses")
+	cmd.Flags().StringVar(&profileFilename, "profile", "", "write a flat profile of the cycles per function to this file, or - for standard output")
+	cmd.Flags().StringVar(&foldedFilename, "folded", "", "write the cycles per call stack as folded stacks for flame graphs to this file, or - for standard output")
+	cmd.Flags().StringVar(&sourceMapFilename, "source-map", "", "source map of a translated VM program (default: Xxx.map next to Xxx.asm if present)")
+	cmd.Flags().StringVar(&resumeFilename, "resume", "", "resume from the state saved in this snapshot file")
+	cmd.Flags().StringVar(&snapshotFilename, "snapshot", "", "save the state at the end of the run to this snapshot file")
+	cmd.Flags().StringVar(&stopAt, "stop-at", "", "stop before executing the instruction at this label or ROM address")
+	cmd.Flags().Uint64Var(&cycles, "cycles", DefaultEmulationCycles, "maximum number of instructions to execute")
+	cmd.Flags().StringVar(&screenshot, "screenshot", "", "write the screen as a PNG image to this file")
+	cmd.Flags().StringVar(&screenStyle, "screen", "", "draw the screen on standard output: braille or blocks")
+	cmd.Flags().IntVar(&scale, "scale", 2, "pixels per character side when drawing the screen")
+	cmd.Flags().StringVar(&compare, "compare", "", "fail unless the screen matches this PNG image")
+
+	return cmd
+}
+
+// errStopped stops a run at the address of --stop-at.
+var errStopped = errors.New("stopped")
+
+// setRAM performs an assignment ADDRESS=VALUE, where ADDRESS is a number or a symbol naming a RAM address.
+func setRAM(computer *emulator.Computer, symbols *assembler.SymbolTable, assignment string) error {
+	name, valueText, ok := strings.Cut(assignment, "=")
+	if !ok {
+		return fmt.Errorf("invalid --set %q: expected ADDRESS=VALUE", assignment)
+	}
+	value, err := strconv.ParseInt(valueText, 0, 32)
+	if err != nil || value < -32768 || value > 65535 {
+		return fmt.Errorf("invalid --set %q: invalid value", assignment)
+	}
+	var address uint64
+	if symbols.Contains(name) && !symbols.IsLabel(name) {
+		address = uint64(symbols.GetAddress(name))
+	} else if address, err = strconv.ParseUint(name, 0, 16); err != nil || address >= emulator.RAMSize {
+		return fmt.Errorf("invalid --set %q: unknown symbol or invalid address", assignment)
+	}
+	computer.RAM[address] = uint16(value)
+	return nil
+}
+
+// createTrace creates a CSV or VCD trace file according to the extension of filename.
+func createTrace(filename string) (*os.File, emulator.TraceWriter, error) {
+	var newWriter func(io.Writer) emulator.TraceWriter
+	switch filepath.Ext(filename) {
+	case ".csv":
+		newWriter = func(w io.Writer) emulator.TraceWriter { return emulator.NewCSVTraceWriter(w) }
+	case ".vcd":
+		newWriter = func(w io.Writer) emulator.TraceWriter { return emulator.NewVCDTraceWriter(w) }
+	default:
+		return nil, nil, fmt.Errorf("trace file %q must have a .csv or .vcd extension", filename)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, newWriter(f), nil
+}
+
+// newProfiler creates a profiler by VM function if the program has a source map, or else by label.
+func newProfiler(programFilename, sourceMapFilename string, symbols *assembler.SymbolTable) (*profile.Profiler, error) {
+	if sourceMapFilename == "" && filepath.Ext(programFilename) == ".asm" {
+		candidate := strings.TrimSuffix(programFilename, ".asm") + ".map"
+		if _, err := os.Stat(candidate); err == nil {
+			sourceMapFilename = candidate
+		}
+	}
+	if sourceMapFilename == "" {
+		return profile.NewLabelProfiler(symbols), nil
+	}
+	f, err := os.Open(sourceMapFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sourceMap, err := vm.ReadSourceMap(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", sourceMapFilename, err)
+	}
+	return profile.NewVMProfiler(sourceMap), nil
+}
+
+// writeProfile writes a profile to filename, or to standard output if filename is "-".
+func writeProfile(cmd *cobra.Command, filename string, write func(io.Writer) error) error {
+	if filename == "-" {
+		return write(cmd.OutOrStdout())
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// addressRange parses a range of ROM or RAM addresses: FIRST-LAST, a single address, or a symbol.
+// A label spans the ROM addresses up to the next label, and a variable its RAM address.
+func addressRange(spec string, symbols *assembler.SymbolTable, rom bool) (emulator.AddressRange, error) {
+	if symbols.Contains(spec) {
+		address := symbols.GetAddress(spec)
+		if rom && !symbols.IsLabel(spec) {
+			return emulator.AddressRange{}, fmt.Errorf("%s is not a label", spec)
+		} else if !rom && symbols.IsLabel(spec) {
+			return emulator.AddressRange{}, fmt.Errorf("%s is a label of a ROM address", spec)
+		}
+		if !rom {
+			return emulator.AddressRange{First: uint16(address), Last: uint16(address)}, nil
+		}
+		last := emulator.ROMSize - 1
+		for _, symbol := range symbols.Symbols() {
+			if next := symbols.GetAddress(symbol); symbols.IsLabel(symbol) && next > address && next-1 < last {
+				last = next - 1
+			}
+		}
+		return emulator.AddressRange{First: uint16(address), Last: uint16(last)}, nil
+	}
+	firstText, lastText, isRange := strings.Cut(spec, "-")
+	if !isRange {
+		lastText = firstText
+	}
+	first, err := strconv.ParseUint(firstText, 0, 15)
+	if err != nil {
+		return emulator.AddressRange{}, fmt.Errorf("unknown symbol or invalid address range %q", spec)
+	}
+	last, err := strconv.ParseUint(lastText, 0, 15)
+	if err != nil || last < first {
+		return emulator.AddressRange{}, fmt.Errorf("unknown symbol or invalid address range %q", spec)
+	}
+	return emulator.AddressRange{First: uint16(first), Last: uint16(last)}, nil
+}
+
+func saveSnapshot(computer *emulator.Computer, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := emulator.WriteSnapshot(f, computer.Snapshot()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func restoreSnapshot(computer *emulator.Computer, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	snapshot, err := emulator.ReadSnapshot(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := computer.Restore(snapshot); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+func writeScreenshot(filename string, ram []uint16) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := emulator.WriteScreenPNG(f, ram); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func compareScreen(filename string, ram []uint16) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	want, err := emulator.ReadScreenPNG(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	if diff := emulator.ScreenDiff(ram, want); diff > 0 {
+		return fmt.Errorf("screen differs from %s in %d pixels", filename, diff)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// addFormatFlags adds the --write and --check flags of formatters, read by formatFiles.
+func addFormatFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolP("write", "w", false, "rewrite files in place")
+	cmd.Flags().Bool("check", false, "list unformatted files and fail if there are any")
+}
+
+// formatFiles formats files with format, writing the result to standard output, rewriting the files
+// that change with --write, or listing them with --check and failing if there are any.
+func formatFiles(cmd *cobra.Command, files []string, format func(filename string, r io.Reader) ([]byte, error)) error {
+	write, _ := cmd.Flags().GetBool("write")
+	check, _ := cmd.Flags().GetBool("check")
+	if write && check {
+		return fmt.Errorf("--write and --check are mutually exclusive")
+	}
+	var unformatted []string
+	for _, file := range files {
+		original, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		formatted, err := format(file, bytes.NewReader(original))
+		if err != nil {
+			return err
+		}
+		switch {
+		case check:
+			if !bytes.Equal(original, formatted) {
+				fmt.Fprintln(cmd.OutOrStdout(), file)
+				unformatted = append(unformatted, file)
+			}
+		case write:
+			if !bytes.Equal(original, formatted) {
+				if err := os.WriteFile(file, formatted, 0o644); err != nil {
+					return err
+				}
+			}
+		default:
+			cmd.OutOrStdout().Write(formatted)
+		}
+	}
+	if len(unformatted) > 0 {
+		return fmt.Errorf("%d files are not formatted", len(unformatted))
+	}
+	return nil
+}
@@ -4,8 +4,17 @@ import "github.com/spf13/cobra"
 
 func NewRootCommand() *cobra.Command {
 	cmd := &cobra.Command{Use: "nand2tetris"}
-	cmd.AddCommand(assemblerCmd)
+	cmd.AddCommand(NewAssemblerCommand())
+	cmd.AddCommand(NewAsmFmtCommand())
 	cmd.AddCommand(NewVMTranslatorCommand())
+	cmd.AddCommand(NewVMLintCommand())
+	cmd.AddCommand(NewVMFmtCommand())
+	cmd.AddCommand(NewDebugCommand())
+	cmd.AddCommand(NewVMDebugCommand())
+	cmd.AddCommand(NewEmulateCommand())
+	cmd.AddCommand(NewCPUTestCommand())
+	cmd.AddCommand(NewRunCommand())
+	cmd.AddCommand(NewBenchCommand())
 
 	return cmd
 }
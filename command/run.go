@@ -0,0 +1,72 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+	"github.com/benjaminclauss/nand2tetris/live"
+)
+
+// DefaultClockRate is the default number of instructions per second of live runs.
+const DefaultClockRate = 5_000_000
+
+func NewRunCommand() *cobra.Command {
+	var opts loadOptions
+	var clockRate, scale, frameRate int
+	var webAddr, style string
+	terminal := &live.Terminal{}
+	cmd := &cobra.Command{
+		Use:   "run <.asm or .hack file>",
+		Short: "Runs interactive Hack programs live",
+		Long: `
+Run executes a Hack program at --clock instructions per second, or as fast as possible with
+--clock 0, showing its screen and forwarding key presses to its keyboard with the codes of
+the Hack character set, such as 128 for newline and 130 to 133 for the arrow keys.
+
+By default, the screen is drawn in the terminal, in the style braille or blocks, and each key
+typed stays pressed for --key-hold since terminals do not report key releases. Ctrl-C quits.
+
+With --web, the screen is instead shown in a web page served on the given address, such as
+localhost:8080, where the keyboard is forwarded with key presses and releases. Since the page
+controls the keyboard, only loopback addresses are served, and a port alone such as :8080
+listens on 127.0.0.1.
+	`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if clockRate < 0 {
+				return fmt.Errorf("invalid --clock %d: must not be negative", clockRate)
+			}
+			program, err := loadProgram(args[0], opts)
+			if err != nil {
+				return err
+			}
+			machine := live.NewMachine(program.newComputer(), clockRate)
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer cancel()
+			go machine.Run(ctx)
+
+			if webAddr != "" {
+				return live.ServeWeb(ctx, machine, webAddr, func(addr string) {
+					fmt.Fprintf(cmd.ErrOrStderr(), "serving the screen on http://%s, interrupt to quit\n", addr)
+				})
+			}
+			terminal.In, terminal.Out = os.Stdin, cmd.OutOrStdout()
+			terminal.Style, terminal.Scale, terminal.FrameRate = style, scale, frameRate
+			return terminal.Run(ctx, cancel, machine)
+		},
+	}
+	addLoadFlags(cmd, &opts)
+	cmd.Flags().IntVar(&clockRate, "clock", DefaultClockRate, "instructions per second, or 0 for as fast as possible")
+	cmd.Flags().StringVar(&webAddr, "web", "", "serve the screen on this loopback address, such as localhost:8080, instead of the terminal")
+	cmd.Flags().StringVar(&style, "style", emulator.StyleBraille, "terminal drawing style: braille or blocks")
+	cmd.Flags().IntVar(&scale, "scale", 2, "pixels per character side in the terminal")
+	cmd.Flags().IntVar(&frameRate, "fps", 30, "terminal frames per second")
+	cmd.Flags().DurationVar(&terminal.KeyHold, "key-hold", live.DefaultKeyHold, "how long a key typed in the terminal stays pressed")
+
+	return cmd
+}
@@ -0,0 +1,74 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+	"github.com/benjaminclauss/nand2tetris/testscript"
+)
+
+func NewCPUTestCommand() *cobra.Command {
+	var opts loadOptions
+	var keyScript string
+	cmd := &cobra.Command{
+		Use:   "cputest <script.tst>...",
+		Short: "Runs CPU emulator test scripts",
+		Long: `
+The CPU test runner executes test scripts of the CPU emulator of the course, such as
+Mult.tst, which load a .asm or .hack program, set registers and RAM, run the program for a
+number of clock cycles, write the values listed by output-list to an output file and compare
+them with a .cmp file. The keyboard can be set with set KBD or set RAM[24576].
+
+Beyond the commands of the course, screenshot <file> writes the screen as a PNG image and
+compare-screen <file> fails unless the screen matches such an image pixel for pixel.
+
+With --keys, a keyboard script of "cycle key [hold]" lines is replayed into the keyboard of
+every program loaded, where keys are codes or names such as a, space, left or F1.
+	`,
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runner := testscript.NewRunner(func(filename string) (*emulator.Computer, error) {
+				program, err := loadProgram(filename, opts)
+				if err != nil {
+					return nil, err
+				}
+				return program.newComputer(), nil
+			}, cmd.OutOrStdout())
+			if keyScript != "" {
+				events, err := readKeyScript(keyScript)
+				if err != nil {
+					return err
+				}
+				runner.Keys = events
+			}
+			for _, script := range args {
+				if err := runner.RunFile(script); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: comparison ended successfully\n", script)
+			}
+			return nil
+		},
+	}
+	addLoadFlags(cmd, &opts)
+	cmd.Flags().StringVar(&keyScript, "keys", "", "keyboard script replayed into every program loaded")
+
+	return cmd
+}
+
+func readKeyScript(filename string) ([]emulator.KeyEvent, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	events, err := emulator.ParseKeyScript(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return events, nil
+}
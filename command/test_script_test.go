@@ -0,0 +1,58 @@
+package command
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCPUTest runs cputest on scripts, returning its error.
+func runCPUTest(t *testing.T, scripts ...string) error {
+	t.Helper()
+	cmd := NewCPUTestCommand()
+	cmd.SetArgs(scripts)
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	return cmd.Execute()
+}
+
+func TestCompareScreen(t *testing.T) {
+	if err := runCPUTest(t, filepath.Join("..", "4", "fill", "FillScreen.tst")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareScreenDiffers(t *testing.T) {
+	dir := t.TempDir()
+	fill, err := os.ReadFile(filepath.Join("..", "4", "fill", "Fill.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	white, err := os.ReadFile(filepath.Join("..", "4", "fill", "FillWhite.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := `
+load Fill.asm;
+set RAM[24576] 75;
+repeat 1000000 {
+  ticktock;
+}
+screenshot Black.png;
+compare-screen White.png;
+`
+	for name, content := range map[string][]byte{"Fill.asm": fill, "White.png": white, "Fill.tst": []byte(script)} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = runCPUTest(t, filepath.Join(dir, "Fill.tst"))
+	if err == nil || !strings.Contains(err.Error(), "screen differs from White.png in 131072 pixels") {
+		t.Errorf("comparing a black screen with a white image: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Black.png")); err != nil {
+		t.Errorf("screenshot: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/benjaminclauss/nand2tetris/debugger"
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+func NewVMDebugCommand() *cobra.Command {
+	var opts translateOptions
+	var history int
+	cmd := &cobra.Command{
+		Use:   "vmdebug <source>...",
+		Short: "Interactive debugger for VM programs",
+		Long: `
+The VM debugger translates .vm files, or directories of them, like the VM translator, then
+runs the program in an emulator of the Hack computer, reading commands from standard input.
+Execution is presented in terms of the VM source through the source map of the translation.
+
+Breakpoints are set on functions such as Main.main or on lines such as Main.vm:12. Execution
+proceeds by VM command, stepping into or over calls, or by instruction. The backtrace and the
+argument, local, this, that and working stack views are reconstructed from the frames saved in
+RAM by the calls. The last --history instructions are recorded, so that the instruction-level
+reverse-step, reverse-continue, last-write and origin commands can run back through them.
+Type help for the list of commands.
+	`,
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.validate(); err != nil {
+				return err
+			}
+			var vmFiles []string
+			for _, source := range args {
+				files, err := sourceFiles(source)
+				if err != nil {
+					return err
+				}
+				vmFiles = append(vmFiles, files...)
+			}
+			sources := make(map[string][]string)
+			for _, file := range vmFiles {
+				text, err := os.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				sources[filepath.Base(file)] = strings.Split(strings.ReplaceAll(string(text), "\r\n", "\n"), "\n")
+			}
+			var asm, binary bytes.Buffer
+			sourceMap, err := translate(&asm, opts, vmFiles...)
+			if err != nil {
+				return err
+			}
+			symbols, err := Assemble(&asm, &binary)
+			if err != nil {
+				return fmt.Errorf("assembling the translation: %w", err)
+			}
+			program, err := emulator.ReadProgram(&binary)
+			if err != nil {
+				return err
+			}
+
+			computer := emulator.NewComputer(program)
+			computer.History = emulator.NewHistory(history)
+			d := debugger.NewVM(computer, symbols, sourceMap, sources, cmd.OutOrStdout())
+			d.Execute("frame")
+			input := bufio.NewScanner(cmd.InOrStdin())
+			for {
+				fmt.Fprint(cmd.OutOrStdout(), "(vm) ")
+				if !input.Scan() {
+					fmt.Fprintln(cmd.OutOrStdout())
+					return input.Err()
+				}
+				if d.Execute(input.Text()) {
+					return nil
+				}
+			}
+		},
+	}
+	addTranslateFlags(cmd, &opts)
+	cmd.Flags().IntVar(&history, "history", debugger.DefaultHistorySize, "number of instructions recorded for reverse execution")
+
+	return cmd
+}
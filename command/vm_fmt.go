@@ -0,0 +1,39 @@
+package command
+
+import (
+	"github.com/spf13/cobra"
+
+	vm "github.com/benjaminclauss/nand2tetris/virtualmachine"
+)
+
+func NewVMFmtCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vmfmt <source>...",
+		Short: "Formatter for VM programs",
+		Long: `
+The VM formatter rewrites .vm files, or directories of them, in canonical form: commands
+with single spaces between their parts, function bodies indented under their declarations,
+comments preserved and blank lines collapsed.
+
+By default the formatted code is written to standard output. With --write, files are
+rewritten in place. With --check, nothing is written; the files that are not formatted
+are listed and the command fails if there are any, which suits pre-commit hooks.
+	`,
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var files []string
+			for _, source := range args {
+				found, err := sourceFiles(source)
+				if err != nil {
+					return err
+				}
+				files = append(files, found...)
+			}
+			return formatFiles(cmd, files, vm.Format)
+		},
+	}
+	addFormatFlags(cmd)
+
+	return cmd
+}
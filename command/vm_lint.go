@@ -0,0 +1,65 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	vm "github.com/benjaminclauss/nand2tetris/virtualmachine"
+)
+
+func NewVMLintCommand() *cobra.Command {
+	var extern []string
+	cmd := &cobra.Command{
+		Use:   "vmlint <source>...",
+		Short: "Static analysis of VM programs",
+		Long: `
+The VM linter checks .vm files, or directories of them, as a single program and reports
+each problem found as file:line: message. It detects stack imbalance across paths, jumps
+to undefined labels, calls to undefined functions or with inconsistent argument counts,
+locals beyond those declared by the function or never used, returns with other than one value
+on the stack, missing returns and unreachable code.
+
+Functions of the classes named by --extern, the Jack OS by default, are assumed to be defined.
+	`,
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			linter := vm.NewLinter()
+			linter.Extern = extern
+			for _, source := range args {
+				files, err := sourceFiles(source)
+				if err != nil {
+					return err
+				}
+				for _, file := range files {
+					if err := lintFile(linter, file); err != nil {
+						return err
+					}
+				}
+			}
+			diagnostics := linter.Diagnostics()
+			for _, diagnostic := range diagnostics {
+				fmt.Fprintln(cmd.OutOrStdout(), diagnostic)
+			}
+			if len(diagnostics) > 0 {
+				return fmt.Errorf("%d problems found", len(diagnostics))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&extern, "extern", vm.JackOSClasses, "classes whose functions are defined elsewhere")
+
+	return cmd
+}
+
+func lintFile(linter *vm.Linter, file string) error {
+	vmf, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer vmf.Close()
+	linter.AddFile(file, vmf)
+	return nil
+}
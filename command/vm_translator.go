@@ -1,107 +1,346 @@
 package command
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"strconv"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/spf13/cobra"
 
 	vm "github.com/benjaminclauss/nand2tetris/virtualmachine"
 )
 
+// Bootstrap modes accepted by the vmtranslator --bootstrap flag.
+const (
+	bootstrapAuto   = "auto"
+	bootstrapAlways = "always"
+	bootstrapNever  = "never"
+)
+
+// translateOptions controls how a set of .vm files is translated into one assembly program.
+type translateOptions struct {
+	// bootstrap is one of bootstrapAuto, bootstrapAlways or bootstrapNever.
+	bootstrap     string
+	stackBase     int
+	entryFunction string
+	// annotate precedes each command's assembly with a comment naming its VM source.
+	annotate bool
+	// jobs bounds the number of files translated concurrently.
+	jobs int
+	// live, if not nil, restricts the translation to the functions it contains.
+	live map[string]bool
+}
+
 func NewVMTranslatorCommand() *cobra.Command {
+	var outputFilename, sourceMapFilename string
+	var eliminateDeadFunctions bool
+	opts := translateOptions{}
 	cmd := &cobra.Command{
-		Use: "vmtranslator <source>",
+		Use: "vmtranslator <source>...",
 		Long: `
-The VM translator accepts a single command line parameter, as follows:
+The VM translator translates one or more sources into a single assembly program, as follows:
 
-prompt> VMtranslator source
+prompt> vmtranslator source...
 
-Where source is either a file name of the form Xxx.vm (the extension is mandatory)
+Where each source is either a file name of the form Xxx.vm (the extension is mandatory)
 or a directory name containing one or more .vm files (in which case there is no extension).
 
-The result of the translation is always a single assembly language file named Xxx.asm, 
-created in the same directory as the input Xxx. 
+With a single source, the assembly is written by default to Xxx.asm, next to Xxx.vm or inside
+the directory Xxx. Several sources are translated into one combined program, whose output file
+must be named with --output. An output of "-" writes the assembly to standard output.
+
+Bootstrap code setting SP and calling the entry function is emitted according to --bootstrap:
+"auto" emits it when the file of the entry function's class (Sys.vm for Sys.init) is among
+the sources, "always" and "never" force it on or off.
+
+With --annotate, each command's assembly is preceded by a comment such as
+"// Main.vm:12 push local 3", and a JSON source map relating assembly lines and ROM
+addresses to VM files, lines and functions is written next to the output as Xxx.map.
+
+With --eliminate-dead-functions, functions that cannot be reached by calls from the entry
+function are left out of the output, and the removed functions are reported.
 	`,
-		Args: cobra.ExactArgs(1),
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			info, err := os.Stat(args[0])
-			if err != nil {
-				return fmt.Errorf("error getting FileInfo: %w", err)
+			if err := opts.validate(); err != nil {
+				return err
+			}
+			var vmFiles []string
+			for _, source := range args {
+				files, err := sourceFiles(source)
+				if err != nil {
+					return err
+				}
+				vmFiles = append(vmFiles, files...)
 			}
-			if info.IsDir() {
-				entries, err := os.ReadDir(args[0])
+			if eliminateDeadFunctions {
+				live, err := liveFunctions(cmd.ErrOrStderr(), opts.entryFunction, vmFiles)
 				if err != nil {
 					return err
 				}
-				var vmFiles []string
-				for _, entry := range entries {
-					if strings.HasSuffix(entry.Name(), ".vm") {
-						vmFiles = append(vmFiles, filepath.Join(args[0]+"/", entry.Name()))
-					}
+				opts.live = live
+			}
+			var err error
+			if outputFilename == "" {
+				if len(args) > 1 {
+					return errors.New("--output is required when translating multiple sources")
+				}
+				if outputFilename, err = defaultOutputFilename(args[0]); err != nil {
+					return err
 				}
-				return translate(info.Name()+".asm", vmFiles...)
 			}
-			return translate(strings.TrimRight(filepath.Base(args[0]), ".vm")+".asm", args[0])
+			if sourceMapFilename == "" && opts.annotate && outputFilename != "-" {
+				sourceMapFilename = strings.TrimSuffix(outputFilename, ".asm") + ".map"
+			}
+			// The translation is complete before anything is written, so that an error leaves no partial output.
+			var asm bytes.Buffer
+			sourceMap, err := translate(&asm, opts, vmFiles...)
+			if err != nil {
+				return err
+			}
+			if outputFilename == "-" {
+				if _, err := asm.WriteTo(cmd.OutOrStdout()); err != nil {
+					return err
+				}
+			} else if err := os.WriteFile(outputFilename, asm.Bytes(), 0o666); err != nil {
+				return err
+			}
+			if sourceMapFilename == "" {
+				return nil
+			}
+			return writeSourceMap(sourceMapFilename, sourceMap)
 		},
 	}
+	cmd.Flags().StringVarP(&outputFilename, "output", "o", "", `output .asm file, or "-" for standard output (default: Xxx.asm next to the source)`)
+	addTranslateFlags(cmd, &opts)
+	cmd.Flags().BoolVar(&opts.annotate, "annotate", false, "annotate the assembly with VM source comments and write a source map")
+	cmd.Flags().StringVar(&sourceMapFilename, "source-map", "", "source map output file (default: Xxx.map next to the output when annotating)")
+	cmd.Flags().BoolVar(&eliminateDeadFunctions, "eliminate-dead-functions", false, "leave out functions unreachable from the entry function")
 
 	return cmd
 }
 
-func translate(outputFilename string, files ...string) error {
-	output, err := os.Create(outputFilename)
+// addTranslateFlags adds the flags of the commands translating VM programs, checked by translateOptions.validate.
+func addTranslateFlags(cmd *cobra.Command, opts *translateOptions) {
+	cmd.Flags().StringVar(&opts.bootstrap, "bootstrap", bootstrapAuto, "emit bootstrap code: auto, always or never")
+	cmd.Flags().IntVar(&opts.stackBase, "sp", vm.DefaultStackBase, "initial stack pointer set by the bootstrap code")
+	cmd.Flags().StringVar(&opts.entryFunction, "entry", vm.DefaultEntryFunction, "function called by the bootstrap code")
+	cmd.Flags().IntVarP(&opts.jobs, "jobs", "j", runtime.GOMAXPROCS(0), "number of files to translate concurrently")
+}
+
+// validate checks the options set by the flags of addTranslateFlags.
+func (opts *translateOptions) validate() error {
+	switch opts.bootstrap {
+	case bootstrapAuto, bootstrapAlways, bootstrapNever:
+	default:
+		return fmt.Errorf("invalid --bootstrap %q: must be auto, always or never", opts.bootstrap)
+	}
+	if opts.jobs < 1 {
+		return fmt.Errorf("invalid --jobs %d: must be at least 1", opts.jobs)
+	}
+	if opts.stackBase < 0 || opts.stackBase > 0x7FFF {
+		return fmt.Errorf("invalid --sp %d: must be a RAM address", opts.stackBase)
+	}
+	return nil
+}
+
+// sourceFiles returns the .vm files named by source, which is either a .vm file or a directory of them.
+func sourceFiles(source string) ([]string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, fmt.Errorf("error getting FileInfo: %w", err)
+	}
+	if !info.IsDir() {
+		if filepath.Ext(source) != ".vm" {
+			return nil, fmt.Errorf("source file %q must have a .vm extension", source)
+		}
+		return []string{source}, nil
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return nil, err
+	}
+	var vmFiles []string
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".vm" {
+			vmFiles = append(vmFiles, filepath.Join(source, entry.Name()))
+		}
+	}
+	if len(vmFiles) == 0 {
+		return nil, fmt.Errorf("directory %q contains no .vm files", source)
+	}
+	return vmFiles, nil
+}
+
+// defaultOutputFilename returns Xxx.asm in the same directory as the source Xxx.vm, or inside the source directory Xxx.
+func defaultOutputFilename(source string) (string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", fmt.Errorf("error getting FileInfo: %w", err)
+	}
+	if !info.IsDir() {
+		return strings.TrimSuffix(source, ".vm") + ".asm", nil
+	}
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(source, filepath.Base(abs)+".asm"), nil
+}
+
+// nopCloser keeps the CodeWriter from closing a stream it does not own, such as standard output.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// translatedFile is the assembly of one .vm file, translated into its own buffer.
+type translatedFile struct {
+	asm       bytes.Buffer
+	sourceMap *vm.SourceMap
+	lines     int
+	rom       int
+	err       error
+}
+
+// translate writes the assembly translation of files to output and returns its source map.
+// Files are translated concurrently into separate buffers, which are then concatenated in order,
+// so the output does not depend on scheduling. Nothing is written unless every file is translated.
+func translate(output io.Writer, opts translateOptions, files ...string) (*vm.SourceMap, error) {
+	results := make([]*translatedFile, len(files))
+	semaphore := make(chan struct{}, max(opts.jobs, 1))
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			result := &translatedFile{}
+			writer := newTranslationWriter(&result.asm, opts)
+			result.err = translateFile(writer, file, opts.live)
+			result.sourceMap = writer.SourceMap()
+			result.lines, result.rom = writer.Position()
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	for _, result := range results {
+		if result.err != nil {
+			return nil, result.err
+		}
+	}
+
+	sourceMap := &vm.SourceMap{}
+	lines, rom := 0, 0
+	if needsBootstrap(opts, files) {
+		bootstrap := &translatedFile{}
+		writer := newTranslationWriter(&bootstrap.asm, opts)
+		if err := writer.WriteInit(opts.stackBase, opts.entryFunction); err != nil {
+			return nil, err
+		}
+		bootstrap.sourceMap = writer.SourceMap()
+		bootstrap.lines, bootstrap.rom = writer.Position()
+		results = append([]*translatedFile{bootstrap}, results...)
+	}
+	for _, result := range results {
+		if _, err := result.asm.WriteTo(output); err != nil {
+			return nil, err
+		}
+		sourceMap.Append(result.sourceMap, lines, rom)
+		lines += result.lines
+		rom += result.rom
+	}
+	return sourceMap, nil
+}
+
+func newTranslationWriter(buf *bytes.Buffer, opts translateOptions) *vm.CodeWriter {
+	writer := vm.NewCodeWriter(nopCloser{buf})
+	writer.SetAnnotate(opts.annotate)
+	return writer
+}
+
+func writeSourceMap(filename string, sourceMap *vm.SourceMap) error {
+	f, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
-	writer := vm.NewCodeWriter(output)
+	if err := vm.WriteSourceMap(f, sourceMap); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	init := false
+// needsBootstrap reports whether bootstrap code should be emitted for files.
+// In auto mode, it is needed when the class file of the entry function is being translated.
+func needsBootstrap(opts translateOptions, files []string) bool {
+	switch opts.bootstrap {
+	case bootstrapAlways:
+		return true
+	case bootstrapNever:
+		return false
+	}
+	class, _, _ := strings.Cut(opts.entryFunction, ".")
 	for _, filename := range files {
-		if strings.HasSuffix(filename, "Sys.vm") {
-			init = true
+		if filepath.Base(filename) == class+".vm" {
+			return true
 		}
 	}
-	if init {
-		writer.WriteInit()
-	}
+	return false
+}
 
+// liveFunctions returns the functions reachable from the entry function in files, and reports the others to w.
+func liveFunctions(w io.Writer, entryFunction string, files []string) (map[string]bool, error) {
+	graph := vm.NewCallGraph()
 	for _, file := range files {
-		writer.SetFilename(file)
-		vmf, err := os.Open(file)
-		if err != nil {
-			return err
+		if err := graph.AddFile(file); err != nil {
+			return nil, err
 		}
-		parser := vm.NewParser(vmf)
-		for parser.HasMoreCommands() {
-			parser.Advance()
-			switch parser.CommandType() {
-			case vm.CArithmetic:
-				writer.WriteArithmetic(parser.Arg1())
-			case vm.CPush:
-				index, _ := strconv.Atoi(parser.Arg2())
-				writer.WritePushPop(vm.CPush, parser.Arg1(), index)
-			case vm.CPop:
-				index, _ := strconv.Atoi(parser.Arg2())
-				writer.WritePushPop(vm.CPop, parser.Arg1(), index)
-			case vm.CLabel:
-				writer.WriteLabel(parser.Arg1())
-			case vm.CIf:
-				writer.WriteIf(parser.Arg1())
-			case vm.CGoTo:
-				writer.WriteGoto(parser.Arg1())
-			case vm.CFuntion:
-				numLocals, _ := strconv.Atoi(parser.Arg2())
-				writer.WriteFunction(parser.Arg1(), numLocals)
-			case vm.CReturn:
-				writer.WriteReturn()
-			case vm.CCall:
-				nArgs, _ := strconv.Atoi(parser.Arg2())
-				writer.WriteCall(parser.Arg1(), nArgs)
-			}
+	}
+	if !graph.Defines(entryFunction) {
+		return nil, fmt.Errorf("cannot eliminate dead functions: entry function %s is not defined", entryFunction)
+	}
+	live := graph.Reachable(entryFunction)
+	var dead []string
+	for _, function := range graph.Functions {
+		if !live[function] {
+			dead = append(dead, function)
+		}
+	}
+	fmt.Fprintf(w, "removed %d of %d functions unreachable from %s\n", len(dead), len(graph.Functions), entryFunction)
+	for _, function := range dead {
+		fmt.Fprintf(w, "  %s\n", function)
+	}
+	return live, nil
+}
+
+// translateFile translates a .vm file with writer.
+// If live is not nil, the instructions of functions it does not contain are skipped.
+func translateFile(writer *vm.CodeWriter, file string, live map[string]bool) error {
+	instructions, err := vm.ParseFile(file)
+	if err != nil {
+		return err
+	}
+	writer.SetFilename(file)
+	dead := false
+	for _, instruction := range instructions {
+		if instruction.Op == vm.OpFunction {
+			dead = live != nil && !live[instruction.Label]
+		}
+		if dead {
+			continue
+		}
+		if err := writer.WriteInstruction(instruction); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -0,0 +1,304 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates empty files with the given names in dir.
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSourceFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "Item.vm", "Mvm.vm", "MySys.vm", "a.b.vm", "Main", "Main.vmx")
+	if err := os.Mkdir(filepath.Join(dir, "Sub.vm"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	empty := t.TempDir()
+	writeFiles(t, empty, "README")
+
+	all := []string{
+		filepath.Join(dir, "Item.vm"), filepath.Join(dir, "Mvm.vm"),
+		filepath.Join(dir, "MySys.vm"), filepath.Join(dir, "a.b.vm"),
+	}
+	tests := []struct {
+		source  string
+		want    []string
+		wantErr bool
+	}{
+		{source: filepath.Join(dir, "Item.vm"), want: []string{filepath.Join(dir, "Item.vm")}},
+		{source: filepath.Join(dir, "Mvm.vm"), want: []string{filepath.Join(dir, "Mvm.vm")}},
+		{source: filepath.Join(dir, "MySys.vm"), want: []string{filepath.Join(dir, "MySys.vm")}},
+		{source: filepath.Join(dir, "a.b.vm"), want: []string{filepath.Join(dir, "a.b.vm")}},
+		{source: filepath.Join(dir, "Main"), wantErr: true},
+		{source: filepath.Join(dir, "Main.vmx"), wantErr: true},
+		{source: filepath.Join(dir, "Missing.vm"), wantErr: true},
+		{source: dir, want: all},
+		{source: dir + string(filepath.Separator), want: all},
+		{source: filepath.Join(dir, "Sub.vm", ".."), want: all},
+		{source: dir + string(filepath.Separator) + ".", want: all},
+		{source: empty, wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := sourceFiles(test.source)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("sourceFiles(%q) = %q, want an error", test.source, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("sourceFiles(%q): %v", test.source, err)
+			continue
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("sourceFiles(%q) = %q, want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestDefaultOutputFilename(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "Prog")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, "Item.vm", "Mvm.vm", "MySys.vm", "a.b.vm", "Main")
+
+	tests := []struct {
+		source, want string
+	}{
+		{filepath.Join(dir, "Item.vm"), filepath.Join(dir, "Item.asm")},
+		{filepath.Join(dir, "Mvm.vm"), filepath.Join(dir, "Mvm.asm")},
+		{filepath.Join(dir, "MySys.vm"), filepath.Join(dir, "MySys.asm")},
+		{filepath.Join(dir, "a.b.vm"), filepath.Join(dir, "a.b.asm")},
+		{filepath.Join(dir, "Main"), filepath.Join(dir, "Main.asm")},
+		{dir, filepath.Join(dir, "Prog.asm")},
+		{dir + string(filepath.Separator), filepath.Join(dir, "Prog.asm")},
+		// The base name of "." is not the directory's, which must come from its absolute path.
+		{dir + string(filepath.Separator) + ".", filepath.Join(dir, "Prog.asm")},
+	}
+	for _, test := range tests {
+		got, err := defaultOutputFilename(test.source)
+		if err != nil {
+			t.Errorf("defaultOutputFilename(%q): %v", test.source, err)
+			continue
+		}
+		if filepath.Clean(got) != test.want {
+			t.Errorf("defaultOutputFilename(%q) = %q, want %q", test.source, got, test.want)
+		}
+	}
+	if got, err := defaultOutputFilename(filepath.Join(dir, "Missing.vm")); err == nil {
+		t.Errorf("defaultOutputFilename of a missing file = %q, want an error", got)
+	}
+}
+
+func TestNeedsBootstrap(t *testing.T) {
+	auto := translateOptions{bootstrap: bootstrapAuto, entryFunction: "Sys.init"}
+	tests := []struct {
+		name  string
+		opts  translateOptions
+		files []string
+		want  bool
+	}{
+		{"Sys.vm", auto, []string{"Main.vm", "Sys.vm"}, true},
+		{"Sys.vm in a directory", auto, []string{"dir/Main.vm", "dir/Sys.vm"}, true},
+		{"MySys.vm is not Sys.vm", auto, []string{"dir/MySys.vm"}, false},
+		{"Sys.vm.vm is not Sys.vm", auto, []string{"Sys.vm.vm"}, false},
+		{"no Sys.vm", auto, []string{"Main.vm"}, false},
+		{"other entry class", translateOptions{bootstrap: bootstrapAuto, entryFunction: "Main.main"}, []string{"Main.vm"}, true},
+		{"always", translateOptions{bootstrap: bootstrapAlways, entryFunction: "Sys.init"}, []string{"Main.vm"}, true},
+		{"never", translateOptions{bootstrap: bootstrapNever, entryFunction: "Sys.init"}, []string{"Sys.vm"}, false},
+	}
+	for _, test := range tests {
+		if got := needsBootstrap(test.opts, test.files); got != test.want {
+			t.Errorf("%s: needsBootstrap(%q) = %v, want %v", test.name, test.files, got, test.want)
+		}
+	}
+}
+
+func TestTranslateOptionsValidate(t *testing.T) {
+	valid := translateOptions{bootstrap: bootstrapAuto, stackBase: 256, jobs: 1}
+	tests := []struct {
+		name    string
+		change  func(*translateOptions)
+		wantErr bool
+	}{
+		{"defaults", func(*translateOptions) {}, false},
+		{"highest stack base", func(opts *translateOptions) { opts.stackBase = 0x7FFF }, false},
+		{"unknown bootstrap", func(opts *translateOptions) { opts.bootstrap = "sometimes" }, true},
+		{"no jobs", func(opts *translateOptions) { opts.jobs = 0 }, true},
+		{"negative stack base", func(opts *translateOptions) { opts.stackBase = -5 }, true},
+		{"stack base past RAM", func(opts *translateOptions) { opts.stackBase = 0x8000 }, true},
+	}
+	for _, test := range tests {
+		opts := valid
+		test.change(&opts)
+		if err := opts.validate(); (err != nil) != test.wantErr {
+			t.Errorf("%s: validate() = %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestVMDebugRejectsInvalidOptions(t *testing.T) {
+	for _, args := range [][]string{{"--sp", "-5"}, {"--jobs", "0"}, {"--bootstrap", "sometimes"}} {
+		cmd := NewVMDebugCommand()
+		cmd.SetArgs(append(args, "Missing.vm"))
+		cmd.SetOut(io.Discard)
+		cmd.SetErr(io.Discard)
+		err := cmd.Execute()
+		if err == nil || !strings.Contains(err.Error(), "invalid "+args[0]) {
+			t.Errorf("vmdebug %q: %v, want an invalid %s error", args, err, args[0])
+		}
+	}
+}
+
+// runVMTranslator runs vmtranslator with args, returning its standard output.
+func runVMTranslator(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var stdout bytes.Buffer
+	cmd := NewVMTranslatorCommand()
+	cmd.SetArgs(args)
+	cmd.SetOut(&stdout)
+	cmd.SetErr(io.Discard)
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+func TestTranslateJobs(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("..", "8", "FunctionCalls", "*"))
+	if err != nil || len(dirs) == 0 {
+		t.Fatalf("no FunctionCalls directories: %v", err)
+	}
+	out := t.TempDir()
+	for _, dir := range dirs {
+		var outputs [][]byte
+		for _, jobs := range []string{"1", "8"} {
+			output := filepath.Join(out, filepath.Base(dir)+"-"+jobs+".asm")
+			if _, err := runVMTranslator(t, "-j", jobs, "--annotate", "-o", output, dir); err != nil {
+				t.Fatalf("%s -j %s: %v", dir, jobs, err)
+			}
+			for _, name := range []string{output, strings.TrimSuffix(output, ".asm") + ".map"} {
+				content, err := os.ReadFile(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				outputs = append(outputs, content)
+			}
+		}
+		if !bytes.Equal(outputs[0], outputs[2]) {
+			t.Errorf("%s: the assembly of -j 1 and -j 8 differ", dir)
+		}
+		if !bytes.Equal(outputs[1], outputs[3]) {
+			t.Errorf("%s: the source maps of -j 1 and -j 8 differ", dir)
+		}
+	}
+}
+
+func TestTranslateErrorWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	for name, source := range map[string]string{
+		"Good.vm": "function Good.f 0\npush constant 1\nreturn\n",
+		"Bad.vm":  "function Bad.f 0\npush nowhere 1\nreturn\n",
+		"Last.vm": "function Last.f 0\npush constant 2\nreturn\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(dir, "Prog.asm")
+	if err := os.WriteFile(output, []byte("previous\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, jobs := range []string{"1", "8"} {
+		if _, err := runVMTranslator(t, "-j", jobs, "-o", output, dir); err == nil {
+			t.Errorf("-j %s: translating a malformed file succeeded", jobs)
+		}
+		if content, err := os.ReadFile(output); err != nil || string(content) != "previous\n" {
+			t.Errorf("-j %s: a failed translation changed the output file to %q, %v", jobs, content, err)
+		}
+		stdout, err := runVMTranslator(t, "-j", jobs, "-o", "-", dir)
+		if err == nil {
+			t.Errorf("-j %s: translating a malformed file to standard output succeeded", jobs)
+		}
+		if stdout != "" {
+			t.Errorf("-j %s: a failed translation wrote %q to standard output", jobs, stdout)
+		}
+	}
+}
+
+// writeSources writes VM files named after the keys of files into dir.
+func writeSources(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEliminateDeadFunctions(t *testing.T) {
+	dir := t.TempDir()
+	writeSources(t, dir, map[string]string{
+		"Sys.vm": "function Sys.init 0\ncall Main.main 0\nlabel HALT\ngoto HALT\n",
+		"Main.vm": "function Main.main 0\ncall Main.helper 0\nreturn\n" +
+			"function Main.helper 0\npush constant 1\nreturn\n" +
+			"function Main.dead 0\ncall Main.helper 0\nreturn\n",
+	})
+	output := filepath.Join(dir, "Prog.asm")
+	var stderr bytes.Buffer
+	cmd := NewVMTranslatorCommand()
+	cmd.SetArgs([]string{"--eliminate-dead-functions", "-o", output, dir})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(&stderr)
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	asm, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, function := range []string{"Sys.init", "Main.main", "Main.helper"} {
+		if !bytes.Contains(asm, []byte("("+function+")\n")) {
+			t.Errorf("the pruned assembly leaves out the live function %s", function)
+		}
+	}
+	if bytes.Contains(asm, []byte("Main.dead")) {
+		t.Errorf("the pruned assembly holds the dead function Main.dead")
+	}
+	if want := "removed 1 of 4 functions unreachable from Sys.init\n  Main.dead\n"; stderr.String() != want {
+		t.Errorf("reported %q, want %q", stderr.String(), want)
+	}
+}
+
+func TestEliminateDeadFunctionsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"missing entry function", "function Main.main 0\nreturn\n", "entry function Sys.init is not defined"},
+		{"malformed call", "function Sys.init 0\ncall\nreturn\n", "call takes 2 arguments, got 0"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		writeSources(t, dir, map[string]string{"Sys.vm": test.source})
+		_, err := runVMTranslator(t, "--eliminate-dead-functions", "-o", "-", dir)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminclauss/nand2tetris/assembler"
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+// DefaultMaxCycles bounds the instructions run by a continue command without breakpoints, so that a program looping
+// forever without halting gives control back.
+const DefaultMaxCycles = 100_000_000
+
+// DefaultHistorySize is the number of instructions recorded for reverse execution, at most about 3 MB worth.
+const DefaultHistorySize = 1 << 16
+
+// A Debugger runs a Hack program in an emulator under the control of textual commands.
+type Debugger struct {
+	Computer *emulator.Computer
+	// Symbols of the program, for naming addresses. It may be empty for binary programs.
+	// The debugger indexes them when created, so they must not change afterwards.
+	Symbols *assembler.SymbolTable
+
+	out         io.Writer
+	commands    []command
+	breakpoints []point
+	watchpoints []point
+	nextPointID int
+	lastCommand string
+	// showPosition shows where the program stopped.
+	showPosition func()
+
+	// Indexes of the symbols by address: the labels of ROM addresses, in address order as well,
+	// and the variables and predefined symbols of RAM addresses.
+	labelsAt       map[uint16][]string
+	labelAddresses []uint16
+	variablesAt    map[uint16][]string
+}
+
+// A breakpoint on a ROM address or a watchpoint on a RAM address.
+type point struct {
+	id      int
+	address uint16
+	name    string
+}
+
+// A command of the debugger. A command without run quits.
+type command struct {
+	names []string
+	usage string
+	run   func(args []string) error
+}
+
+// New creates a debugger of computer, writing its output to out.
+func New(computer *emulator.Computer, symbols *assembler.SymbolTable, out io.Writer) *Debugger {
+	if symbols == nil {
+		symbols = assembler.NewSymbolTable()
+	}
+	d := &Debugger{Computer: computer, Symbols: symbols, out: out, nextPointID: 1}
+	d.indexSymbols()
+	d.showPosition = d.showInstruction
+	d.commands = []command{
+		{[]string{"break", "b"}, "break LABEL|ADDRESS   stop before executing ROM[ADDRESS]", d.breakCommand},
+		{[]string{"watch", "w"}, "watch SYMBOL|ADDRESS   stop after RAM[ADDRESS] is written", d.watchCommand},
+		{[]string{"delete", "d"}, "delete [ID]            delete a breakpoint or watchpoint, or all of them", d.deleteCommand},
+		{[]string{"info", "i"}, "info                   list breakpoints and watchpoints", d.infoCommand},
+		{[]string{"step", "s"}, "step [N]               execute N instructions, 1 by default", d.stepCommand},
+		{[]string{"continue", "c"}, "continue [N]           run until a breakpoint, a watchpoint, the end of the program or N instructions", d.continueCommand},
+		{[]string{"reverse-step", "rs"}, "reverse-step [N]       undo the last N instructions, 1 by default", d.reverseStepCommand},
+		{[]string{"reverse-continue", "rc"}, "reverse-continue [N]   run backwards to a breakpoint, a watchpoint, the oldest instruction recorded or N instructions", d.reverseContinueCommand},
+		{[]string{"last-write", "lw"}, "last-write SYMBOL|ADDRESS   run backwards to the instruction that last wrote RAM[ADDRESS]", d.lastWriteCommand},
+		{[]string{"origin", "o"}, "origin SYMBOL|ADDRESS  show the instruction and cycle that wrote the value of RAM[ADDRESS]", d.originCommand},
+		{[]string{"regs", "r"}, "regs                   show A, D, PC, M and the cycle count", d.regsCommand},
+		{[]string{"mem", "x"}, "mem SYMBOL|ADDRESS [N] show N words of RAM from ADDRESS, 1 by default", d.memCommand},
+		{[]string{"list", "l"}, "list [LABEL|ADDRESS]   disassemble ROM around ADDRESS, PC by default", d.listCommand},
+		{[]string{"set"}, "set A|D|PC|SYMBOL|ADDRESS VALUE   set a register or RAM[ADDRESS]", d.setCommand},
+		{[]string{"screen"}, "screen [FILE.png]      draw the screen, or save it as a PNG image", d.screenCommand},
+		{[]string{"save"}, "save FILE              save a snapshot of the machine state", d.saveCommand},
+		{[]string{"restore"}, "restore FILE           restore a snapshot saved with the same program", d.restoreCommand},
+		{[]string{"reset"}, "reset                  restart the program with cleared RAM", d.resetCommand},
+		{[]string{"help", "h"}, "help                   show this help", d.helpCommand},
+		{[]string{"quit", "q"}, "quit                   exit the debugger", nil},
+	}
+	return d
+}
+
+// Execute runs a command line, returning whether the debugger should quit.
+// An empty line repeats the previous command.
+func (d *Debugger) Execute(line string) (quit bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		if d.lastCommand == "" {
+			return false
+		}
+		fields = strings.Fields(d.lastCommand)
+	} else {
+		d.lastCommand = line
+	}
+	for _, c := range d.commands {
+		if !slices.Contains(c.names, fields[0]) {
+			continue
+		}
+		if c.run == nil {
+			return true
+		}
+		if err := c.run(fields[1:]); err != nil {
+			fmt.Fprintf(d.out, "error: %v\n", err)
+		}
+		return false
+	}
+	fmt.Fprintf(d.out, "unknown command %q, try help\n", fields[0])
+	return false
+}
+
+// override replaces the commands named like the given ones, or adds them before help.
+func (d *Debugger) override(commands ...command) {
+	for _, c := range commands {
+		if i := slices.IndexFunc(d.commands, func(other command) bool { return other.names[0] == c.names[0] }); i >= 0 {
+			d.commands[i] = c
+			continue
+		}
+		help := slices.IndexFunc(d.commands, func(other command) bool { return other.names[0] == "help" })
+		d.commands = slices.Insert(d.commands, help, c)
+	}
+}
+
+func (d *Debugger) breakCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: break LABEL|ADDRESS")
+	}
+	address, err := d.romAddress(args[0])
+	if err != nil {
+		return err
+	}
+	d.addBreakpoint(address, args[0])
+	return nil
+}
+
+func (d *Debugger) addBreakpoint(address uint16, name string) {
+	d.breakpoints = append(d.breakpoints, point{d.nextPointID, address, name})
+	fmt.Fprintf(d.out, "breakpoint %d at ROM[%d]%s\n", d.nextPointID, address, d.location(address))
+	d.nextPointID++
+}
+
+func (d *Debugger) watchCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: watch SYMBOL|ADDRESS")
+	}
+	address, err := d.ramAddress(args[0])
+	if err != nil {
+		return err
+	}
+	d.watchpoints = append(d.watchpoints, point{d.nextPointID, address, args[0]})
+	fmt.Fprintf(d.out, "watchpoint %d on RAM[%d]\n", d.nextPointID, address)
+	d.nextPointID++
+	return nil
+}
+
+func (d *Debugger) deleteCommand(args []string) error {
+	if len(args) == 0 {
+		d.breakpoints, d.watchpoints = nil, nil
+		return nil
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid ID %q", args[0])
+	}
+	hasID := func(p point) bool { return p.id == id }
+	before := len(d.breakpoints) + len(d.watchpoints)
+	d.breakpoints = slices.DeleteFunc(d.breakpoints, hasID)
+	d.watchpoints = slices.DeleteFunc(d.watchpoints, hasID)
+	if len(d.breakpoints)+len(d.watchpoints) == before {
+		return fmt.Errorf("no breakpoint or watchpoint %d", id)
+	}
+	return nil
+}
+
+func (d *Debugger) infoCommand([]string) error {
+	for _, p := range d.breakpoints {
+		fmt.Fprintf(d.out, "%d breakpoint ROM[%d]%s\n", p.id, p.address, d.location(p.address))
+	}
+	for _, p := range d.watchpoints {
+		fmt.Fprintf(d.out, "%d watchpoint RAM[%d] %s\n", p.id, p.address, p.name)
+	}
+	return nil
+}
+
+func (d *Debugger) stepCommand(args []string) error {
+	n, err := count(args, 1)
+	if err != nil {
+		return err
+	}
+	d.run(n, false, nil)
+	return nil
+}
+
+func (d *Debugger) continueCommand(args []string) error {
+	n, err := count(args, DefaultMaxCycles)
+	if err != nil {
+		return err
+	}
+	d.run(n, true, nil)
+	return nil
+}
+
+// run executes up to n instructions, stopping early when the program halts, when until returns true after an instruction,
+// or, if checkPoints is set, at watchpoints and breakpoints. It then shows where the program stopped.
+func (d *Debugger) run(n int, checkPoints bool, until func() bool) {
+	c := d.Computer
+	for range n {
+		if c.Halted() {
+			fmt.Fprintln(d.out, "the program has halted")
+			break
+		}
+		effect := c.Step()
+		if checkPoints && d.stopsAt(effect) {
+			break
+		}
+		if until != nil && until() {
+			break
+		}
+	}
+	d.showPosition()
+}
+
+func (d *Debugger) reverseStepCommand(args []string) error {
+	n, err := count(args, 1)
+	if err != nil {
+		return err
+	}
+	return d.reverse(n, false)
+}
+
+func (d *Debugger) reverseContinueCommand(args []string) error {
+	n, err := count(args, DefaultMaxCycles)
+	if err != nil {
+		return err
+	}
+	return d.reverse(n, true)
+}
+
+// reverse undoes up to n instructions, stopping early when the history is exhausted or, if checkPoints is set,
+// before an instruction writing a watchpoint or at a breakpoint. It then shows where the program stopped.
+func (d *Debugger) reverse(n int, checkPoints bool) error {
+	c := d.Computer
+	if c.History == nil {
+		return fmt.Errorf("no history is recorded")
+	}
+	for range n {
+		entry, ok := c.StepBack()
+		if !ok {
+			fmt.Fprintln(d.out, "reached the oldest instruction recorded")
+			break
+		}
+		if checkPoints && d.stopsAt(entry.Effect) {
+			break
+		}
+	}
+	d.showPosition()
+	return nil
+}
+
+// lastWrite returns the history index of the last instruction that wrote RAM[address].
+func (d *Debugger) lastWrite(address uint16) (int, error) {
+	h := d.Computer.History
+	if h == nil {
+		return 0, fmt.Errorf("no history is recorded")
+	}
+	i, ok := h.LastWrite(address)
+	if !ok {
+		return 0, fmt.Errorf("RAM[%d] was not written in the last %d instructions", address, h.Len())
+	}
+	return i, nil
+}
+
+func (d *Debugger) lastWriteCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: last-write SYMBOL|ADDRESS")
+	}
+	address, err := d.ramAddress(args[0])
+	if err != nil {
+		return err
+	}
+	i, err := d.lastWrite(address)
+	if err != nil {
+		return err
+	}
+	d.showWrite(address, d.Computer.History.Entry(i))
+	return d.reverse(i+1, false)
+}
+
+func (d *Debugger) originCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: origin SYMBOL|ADDRESS")
+	}
+	address, err := d.ramAddress(args[0])
+	if err != nil {
+		return err
+	}
+	i, err := d.lastWrite(address)
+	if err != nil {
+		return err
+	}
+	d.showWrite(address, d.Computer.History.Entry(i))
+	return nil
+}
+
+func (d *Debugger) showWrite(address uint16, entry emulator.HistoryEntry) {
+	name := ""
+	if names := d.ramNames(address); len(names) > 0 {
+		name = " " + strings.Join(names, ", ")
+	}
+	fmt.Fprintf(d.out, "RAM[%d]%s written at cycle %d by ROM[%d]%s: %s: %d -> %d\n",
+		address, name, entry.Cycle, entry.PC, d.location(entry.PC),
+		assembler.Disassemble(entry.Instruction), int16(entry.OldM), int16(entry.OutM))
+}
+
+// stopsAt tells whether an instruction hit a watchpoint, or a breakpoint is set at the next one, and reports it.
+func (d *Debugger) stopsAt(effect emulator.Effect) bool {
+	if effect.WriteM {
+		if j := slices.IndexFunc(d.watchpoints, func(p point) bool { return p.address == effect.AddressM }); j >= 0 {
+			p := d.watchpoints[j]
+			fmt.Fprintf(d.out, "watchpoint %d: RAM[%d] %s written by ROM[%d] %s: %d -> %d\n",
+				p.id, p.address, p.name, effect.PC, assembler.Disassemble(effect.Instruction), int16(effect.OldM), int16(effect.OutM))
+			return true
+		}
+	}
+	if j := slices.IndexFunc(d.breakpoints, func(p point) bool { return p.address == d.Computer.PC }); j >= 0 {
+		fmt.Fprintf(d.out, "breakpoint %d\n", d.breakpoints[j].id)
+		return true
+	}
+	return false
+}
+
+func (d *Debugger) regsCommand([]string) error {
+	c := d.Computer
+	fmt.Fprintf(d.out, "A=%d D=%d M=%d PC=%d cycle=%d\n", int16(c.A), int16(c.D), int16(c.RAM[c.A&(emulator.RAMSize-1)]), c.PC, c.Cycle)
+	return nil
+}
+
+func (d *Debugger) memCommand(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: mem SYMBOL|ADDRESS [N]")
+	}
+	address, err := d.ramAddress(args[0])
+	if err != nil {
+		return err
+	}
+	n, err := count(args[1:], 1)
+	if err != nil {
+		return err
+	}
+	for i := range n {
+		a := (int(address) + i) & (emulator.RAMSize - 1)
+		value := d.Computer.RAM[a]
+		name := ""
+		if names := d.ramNames(uint16(a)); len(names) > 0 {
+			name = " " + strings.Join(names, ", ")
+		}
+		fmt.Fprintf(d.out, "RAM[%d]%s = %d (0x%04x)\n", a, name, int16(value), value)
+	}
+	return nil
+}
+
+func (d *Debugger) listCommand(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: list [LABEL|ADDRESS]")
+	}
+	center := d.Computer.PC
+	if len(args) == 1 {
+		var err error
+		if center, err = d.romAddress(args[0]); err != nil {
+			return err
+		}
+	}
+	const context = 5
+	start := max(int(center)-context, 0)
+	end := min(int(center)+context, emulator.ROMSize-1)
+	for address := start; address <= end; address++ {
+		for _, label := range d.labels(uint16(address)) {
+			fmt.Fprintf(d.out, "        (%s)\n", label)
+		}
+		marker := "  "
+		if address == int(d.Computer.PC) {
+			marker = "=>"
+		}
+		fmt.Fprintf(d.out, "%s %5d %s\n", marker, address, d.disassemble(uint16(address)))
+	}
+	return nil
+}
+
+func (d *Debugger) setCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: set A|D|PC|SYMBOL|ADDRESS VALUE")
+	}
+	value, err := strconv.ParseInt(args[1], 0, 32)
+	if err != nil || value < -32768 || value > 65535 {
+		return fmt.Errorf("invalid value %q", args[1])
+	}
+	c := d.Computer
+	switch args[0] {
+	case "A":
+		c.A = uint16(value)
+	case "D":
+		c.D = uint16(value)
+	case "PC":
+		c.PC = uint16(value) & (emulator.ROMSize - 1)
+	default:
+		address, err := d.ramAddress(args[0])
+		if err != nil {
+			return err
+		}
+		c.RAM[address] = uint16(value)
+	}
+	// Undoing the instructions before the change would not restore the state they were executed in.
+	if c.History != nil {
+		c.History.Clear()
+	}
+	return nil
+}
+
+func (d *Debugger) screenCommand(args []string) error {
+	switch len(args) {
+	case 0:
+		return emulator.RenderScreen(d.out, d.Computer.RAM, emulator.StyleBraille, 2)
+	case 1:
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		if err := emulator.WriteScreenPNG(f, d.Computer.RAM); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return fmt.Errorf("usage: screen [FILE.png]")
+}
+
+func (d *Debugger) saveCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: save FILE")
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := emulator.WriteSnapshot(f, d.Computer.Snapshot()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(d.out, "saved the state at cycle %d to %s\n", d.Computer.Cycle, args[0])
+	return nil
+}
+
+func (d *Debugger) restoreCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: restore FILE")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	snapshot, err := emulator.ReadSnapshot(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	if err := d.Computer.Restore(snapshot); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	d.showPosition()
+	return nil
+}
+
+func (d *Debugger) resetCommand([]string) error {
+	d.Computer.Reset()
+	d.showPosition()
+	return nil
+}
+
+func (d *Debugger) helpCommand([]string) error {
+	for _, c := range d.commands {
+		alias := ""
+		if len(c.names) > 1 {
+			alias = c.names[1]
+		}
+		fmt.Fprintf(d.out, "%-5s %s\n", alias, c.usage)
+	}
+	fmt.Fprintln(d.out, "An empty line repeats the previous command.")
+	return nil
+}
+
+// showInstruction shows the instruction about to be executed.
+func (d *Debugger) showInstruction() {
+	pc := d.Computer.PC
+	fmt.Fprintf(d.out, "ROM[%d]%s: %s\n", pc, d.location(pc), d.disassemble(pc))
+}
+
+// disassemble returns the instruction at a ROM address, with the symbols an A-instruction may stand for.
+func (d *Debugger) disassemble(address uint16) string {
+	instruction := d.Computer.ROM[address]
+	text := assembler.Disassemble(instruction)
+	if instruction&0x8000 != 0 {
+		return text
+	}
+	// An A-instruction followed by a jump addresses ROM, otherwise it most likely addresses RAM.
+	var names []string
+	if next := d.Computer.ROM[(address+1)&(emulator.ROMSize-1)]; next&0x8000 != 0 && next&0x07 != 0 {
+		names = d.labels(instruction)
+	} else {
+		names = d.ramNames(instruction)
+	}
+	if len(names) > 0 {
+		text += " // " + strings.Join(names, ", ")
+	}
+	return text
+}
+
+// location names a ROM address relative to the closest label at or before it, such as " <LOOP+2>".
+func (d *Debugger) location(address uint16) string {
+	i := sort.Search(len(d.labelAddresses), func(i int) bool { return d.labelAddresses[i] > address }) - 1
+	if i < 0 {
+		return ""
+	}
+	labelAddress := d.labelAddresses[i]
+	best := d.labelsAt[labelAddress][0]
+	if labelAddress == address {
+		return " <" + best + ">"
+	}
+	return fmt.Sprintf(" <%s+%d>", best, address-labelAddress)
+}
+
+// labels returns the labels of a ROM address.
+func (d *Debugger) labels(address uint16) []string {
+	return d.labelsAt[address]
+}
+
+// ramNames returns the symbols naming a RAM address.
+func (d *Debugger) ramNames(address uint16) []string {
+	return d.variablesAt[address]
+}
+
+// indexSymbols indexes the symbols by address, so that naming addresses does not scan the symbol table.
+func (d *Debugger) indexSymbols() {
+	d.labelsAt, d.variablesAt = make(map[uint16][]string), make(map[uint16][]string)
+	d.labelAddresses = nil
+	// Symbols are sorted, so the names of each address are too.
+	for _, symbol := range d.Symbols.Symbols() {
+		address := uint16(d.Symbols.GetAddress(symbol))
+		if !d.Symbols.IsLabel(symbol) {
+			d.variablesAt[address] = append(d.variablesAt[address], symbol)
+			continue
+		}
+		if len(d.labelsAt[address]) == 0 {
+			d.labelAddresses = append(d.labelAddresses, address)
+		}
+		d.labelsAt[address] = append(d.labelsAt[address], symbol)
+	}
+	slices.Sort(d.labelAddresses)
+}
+
+// romAddress returns the ROM address of a label or number.
+func (d *Debugger) romAddress(s string) (uint16, error) {
+	if d.Symbols.Contains(s) {
+		if !d.Symbols.IsLabel(s) {
+			return 0, fmt.Errorf("%s is not a label", s)
+		}
+		return uint16(d.Symbols.GetAddress(s)), nil
+	}
+	return parseAddress(s, emulator.ROMSize)
+}
+
+// ramAddress returns the RAM address of a symbol or number.
+func (d *Debugger) ramAddress(s string) (uint16, error) {
+	if d.Symbols.Contains(s) {
+		if d.Symbols.IsLabel(s) {
+			return 0, fmt.Errorf("%s is a label of a ROM address", s)
+		}
+		return uint16(d.Symbols.GetAddress(s)), nil
+	}
+	return parseAddress(s, emulator.RAMSize)
+}
+
+func parseAddress(s string, size int) (uint16, error) {
+	address, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown symbol or invalid address %q", s)
+	}
+	if address >= uint64(size) {
+		return 0, fmt.Errorf("address %d is out of the range 0 to %d", address, size-1)
+	}
+	return uint16(address), nil
+}
+
+// count returns the positive number given as the only argument, or n without arguments.
+func count(args []string, n int) (int, error) {
+	if len(args) == 0 {
+		return n, nil
+	}
+	value, err := strconv.Atoi(args[0])
+	if err != nil || value < 1 || len(args) > 1 {
+		return 0, fmt.Errorf("invalid count %q", strings.Join(args, " "))
+	}
+	return value, nil
+}
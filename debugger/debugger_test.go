@@ -0,0 +1,75 @@
+package debugger
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/benjaminclauss/nand2tetris/assembler"
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+func TestNamingAddresses(t *testing.T) {
+	symbols := assembler.NewSymbolTable()
+	symbols.AddLabel("START", 0)
+	symbols.AddLabel("LOOP", 4)
+	symbols.AddLabel("AGAIN", 4)
+	symbols.AddLabel("END", 10)
+	symbols.AddEntry("x", 16)
+	symbols.AddEntry("R0", 0)
+	symbols.AddEntry("SP", 0)
+	d := New(emulator.NewComputer(nil), symbols, io.Discard)
+
+	locations := map[uint16]string{0: " <START>", 3: " <START+3>", 4: " <AGAIN>", 9: " <AGAIN+5>", 10: " <END>", 200: " <END+190>"}
+	for address, want := range locations {
+		if got := d.location(address); got != want {
+			t.Errorf("location(%d) = %q, want %q", address, got, want)
+		}
+	}
+	if got := d.labels(4); len(got) != 2 || got[0] != "AGAIN" || got[1] != "LOOP" {
+		t.Errorf("labels(4) = %q, want [AGAIN LOOP]", got)
+	}
+	if got := d.ramNames(0); len(got) != 2 || got[0] != "R0" || got[1] != "SP" {
+		t.Errorf("ramNames(0) = %q, want [R0 SP]", got)
+	}
+	if got := d.ramNames(16); len(got) != 1 || got[0] != "x" {
+		t.Errorf("ramNames(16) = %q, want [x]", got)
+	}
+
+	empty := New(emulator.NewComputer(nil), nil, io.Discard)
+	if got := empty.location(5); got != "" {
+		t.Errorf("location without labels = %q, want none", got)
+	}
+}
+
+// TestWatchHighAddress writes RAM[100] through A = 0x8064, whose most significant bit the CPU ignores for RAM addresses.
+func TestWatchHighAddress(t *testing.T) {
+	program := []uint16{
+		0x7FFF, // @32767
+		0xEC50, // D=!A
+		0x0064, // @100
+		0xE560, // A=D|A
+		0xEFC8, // M=1
+		0x0005, // @5
+		0xEA87, // 0;JMP
+	}
+	computer := emulator.NewComputer(program)
+	computer.History = emulator.NewHistory(100)
+	var out strings.Builder
+	d := New(computer, nil, &out)
+
+	d.Execute("watch 100")
+	d.Execute("continue")
+	if want := "watchpoint 1: RAM[100] 100 written by ROM[4] M=1: 0 -> 1"; !strings.Contains(out.String(), want) {
+		t.Errorf("continue wrote\n%s\nwant %q", out.String(), want)
+	}
+	if computer.PC != 5 || computer.RAM[100] != 1 {
+		t.Errorf("continue stopped at PC=%d with RAM[100] = %d, want PC=5 and 1", computer.PC, computer.RAM[100])
+	}
+
+	out.Reset()
+	d.Execute("last-write 100")
+	if want := "RAM[100] written at cycle 4 by ROM[4]"; !strings.Contains(out.String(), want) {
+		t.Errorf("last-write wrote\n%s\nwant %q", out.String(), want)
+	}
+}
@@ -0,0 +1,360 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminclauss/nand2tetris/assembler"
+	"github.com/benjaminclauss/nand2tetris/emulator"
+	vm "github.com/benjaminclauss/nand2tetris/virtualmachine"
+)
+
+// RAM addresses of the VM registers.
+const (
+	SP   = 0
+	LCL  = 1
+	ARG  = 2
+	THIS = 3
+	THAT = 4
+	TEMP = 5
+)
+
+// maxFrames bounds backtraces, which loop forever on a corrupted stack.
+const maxFrames = 1000
+
+// A VMDebugger debugs a translated VM program in terms of its VM commands and functions, using the source map of its translation.
+// The commands of the Debugger remain available for instruction-level debugging.
+type VMDebugger struct {
+	*Debugger
+	SourceMap *vm.SourceMap
+	// Sources holds the lines of the VM files by base name, for listing them.
+	Sources map[string][]string
+
+	// starts tells the ROM addresses at which the code of a VM command starts.
+	starts map[uint16]bool
+	// functions gives the ROM address and the number of locals of each function.
+	functions map[string]function
+}
+
+type function struct {
+	address uint16
+	locals  int
+}
+
+// A frame of the VM call stack.
+type frame struct {
+	function string
+	// pc is the ROM address being executed in the frame, the call for callers.
+	pc       uint16
+	lcl, arg uint16
+	args     int
+}
+
+// NewVM creates a debugger of computer running a VM program translated with the given source map.
+func NewVM(computer *emulator.Computer, symbols *assembler.SymbolTable, sourceMap *vm.SourceMap, sources map[string][]string, out io.Writer) *VMDebugger {
+	v := &VMDebugger{
+		Debugger:  New(computer, symbols, out),
+		SourceMap: sourceMap,
+		Sources:   sources,
+		starts:    make(map[uint16]bool),
+		functions: make(map[string]function),
+	}
+	for _, m := range sourceMap.Mappings {
+		v.starts[uint16(m.ROMAddress)] = true
+		if fields := strings.Fields(m.Command); len(fields) == 3 && fields[0] == "function" {
+			locals, _ := strconv.Atoi(fields[2])
+			v.functions[fields[1]] = function{uint16(m.ROMAddress), locals}
+		}
+	}
+	v.showPosition = v.showCommand
+	v.override(
+		command{[]string{"break", "b"}, "break FUNCTION|FILE.vm:LINE|LABEL|ADDRESS   stop before a function, VM line or instruction", v.breakCommand},
+		command{[]string{"step", "s"}, "step [N]               execute N VM commands, entering calls", v.stepCommand},
+		command{[]string{"stepi", "si"}, "stepi [N]              execute N instructions, 1 by default", v.Debugger.stepCommand},
+		command{[]string{"next", "n"}, "next [N]               execute N VM commands, stepping over calls", v.nextCommand},
+		command{[]string{"finish", "fin"}, "finish                 run until the current function returns", v.finishCommand},
+		command{[]string{"backtrace", "bt"}, "backtrace              show the call stack", v.backtraceCommand},
+		command{[]string{"frame", "f"}, "frame [N]              show the arguments, locals and working stack of frame N, 0 by default", v.frameCommand},
+		command{[]string{"segment", "seg"}, "segment NAME [N]       show N entries of the segment this, that, pointer, temp or static of the current frame", v.segmentCommand},
+		command{[]string{"list", "l"}, "list [FUNCTION|FILE.vm:LINE]   list the VM source around a line, the current one by default", v.listCommand},
+		command{[]string{"disassemble", "disas"}, "disas [LABEL|ADDRESS]  disassemble ROM around ADDRESS, PC by default", v.Debugger.listCommand},
+	)
+	return v
+}
+
+func (v *VMDebugger) breakCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: break FUNCTION|FILE.vm:LINE|LABEL|ADDRESS")
+	}
+	address, err := v.vmAddress(args[0])
+	if err != nil {
+		return err
+	}
+	v.addBreakpoint(address, args[0])
+	return nil
+}
+
+func (v *VMDebugger) stepCommand(args []string) error {
+	n, err := count(args, 1)
+	if err != nil {
+		return err
+	}
+	commands := 0
+	v.run(DefaultMaxCycles, true, func() bool {
+		if v.starts[v.Computer.PC] {
+			commands++
+		}
+		return commands == n
+	})
+	return nil
+}
+
+func (v *VMDebugger) nextCommand(args []string) error {
+	n, err := count(args, 1)
+	if err != nil {
+		return err
+	}
+	// Commands of called functions run with a higher LCL than the current one.
+	lcl := v.Computer.RAM[LCL]
+	commands := 0
+	v.run(DefaultMaxCycles, true, func() bool {
+		if v.starts[v.Computer.PC] && v.Computer.RAM[LCL] <= lcl {
+			commands++
+		}
+		return commands == n
+	})
+	return nil
+}
+
+func (v *VMDebugger) finishCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: finish")
+	}
+	frames := v.frames()
+	if len(frames) < 2 {
+		return fmt.Errorf("the current function has no caller")
+	}
+	lcl := v.Computer.RAM[LCL]
+	returned := false
+	v.run(DefaultMaxCycles, true, func() bool {
+		returned = v.starts[v.Computer.PC] && v.Computer.RAM[LCL] < lcl
+		return returned
+	})
+	if returned {
+		c := v.Computer
+		fmt.Fprintf(v.out, "%s returned %d\n", frames[0].function, int16(c.RAM[(c.RAM[SP]-1)&(emulator.RAMSize-1)]))
+	}
+	return nil
+}
+
+func (v *VMDebugger) backtraceCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: backtrace")
+	}
+	for i, f := range v.frames() {
+		fmt.Fprintf(v.out, "#%d %s\n", i, v.describeFrame(f))
+	}
+	return nil
+}
+
+func (v *VMDebugger) frameCommand(args []string) error {
+	frames := v.frames()
+	i := 0
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 || n >= len(frames) || len(args) > 1 {
+			return fmt.Errorf("invalid frame %q", strings.Join(args, " "))
+		}
+		i = n
+	}
+	f := frames[i]
+	fmt.Fprintf(v.out, "#%d %s\n", i, v.describeFrame(f))
+	for j := range f.args {
+		v.showWord("argument "+strconv.Itoa(j), f.arg+uint16(j))
+	}
+	locals := v.functions[f.function].locals
+	for j := range locals {
+		v.showWord("local "+strconv.Itoa(j), f.lcl+uint16(j))
+	}
+	// The working stack of a caller ends below the arguments it pushed for its callee.
+	end := v.Computer.RAM[SP]
+	if i > 0 {
+		end = frames[i-1].arg
+	}
+	var stack []string
+	for address := v.stackBase(f); address < end; address++ {
+		stack = append(stack, strconv.Itoa(int(int16(v.Computer.RAM[address&(emulator.RAMSize-1)]))))
+	}
+	fmt.Fprintf(v.out, "stack: [%s]\n", strings.Join(stack, " "))
+	return nil
+}
+
+func (v *VMDebugger) segmentCommand(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: segment this|that|pointer|temp|static [N]")
+	}
+	c := v.Computer
+	switch args[0] {
+	case "this", "that", "pointer", "temp":
+		base, n := uint16(TEMP), 8
+		switch args[0] {
+		case "this":
+			base = c.RAM[THIS]
+		case "that":
+			base = c.RAM[THAT]
+		case "pointer":
+			base, n = THIS, 2
+		}
+		n, err := count(args[1:], n)
+		if err != nil {
+			return err
+		}
+		for j := range n {
+			v.showWord(args[0]+" "+strconv.Itoa(j), base+uint16(j))
+		}
+	case "static":
+		mapping, _ := v.SourceMap.Lookup(int(c.PC))
+		prefix := vm.FileStem(mapping.File) + "."
+		var indexes []int
+		for _, symbol := range v.Symbols.Symbols() {
+			if index, err := strconv.Atoi(strings.TrimPrefix(symbol, prefix)); err == nil && strings.HasPrefix(symbol, prefix) && !v.Symbols.IsLabel(symbol) {
+				indexes = append(indexes, index)
+			}
+		}
+		slices.Sort(indexes)
+		for _, index := range indexes {
+			v.showWord("static "+strconv.Itoa(index), uint16(v.Symbols.GetAddress(prefix+strconv.Itoa(index))))
+		}
+	default:
+		return fmt.Errorf("unknown segment %q", args[0])
+	}
+	return nil
+}
+
+func (v *VMDebugger) listCommand(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: list [FUNCTION|FILE.vm:LINE]")
+	}
+	address := v.Computer.PC
+	if len(args) == 1 {
+		var err error
+		if address, err = v.vmAddress(args[0]); err != nil {
+			return err
+		}
+	}
+	mapping, ok := v.SourceMap.Lookup(int(address))
+	lines := v.Sources[mapping.File]
+	if !ok || mapping.Line < 1 || len(lines) == 0 {
+		return fmt.Errorf("no VM source for ROM[%d]", address)
+	}
+	current, _ := v.SourceMap.Lookup(int(v.Computer.PC))
+	const context = 5
+	for line := max(mapping.Line-context, 1); line <= min(mapping.Line+context, len(lines)); line++ {
+		marker := "  "
+		if current.File == mapping.File && current.Line == line {
+			marker = "=>"
+		}
+		fmt.Fprintf(v.out, "%s %5d %s\n", marker, line, lines[line-1])
+	}
+	return nil
+}
+
+// showCommand shows the VM command about to be executed, and the instruction if execution is within its code.
+func (v *VMDebugger) showCommand() {
+	pc := v.Computer.PC
+	mapping, ok := v.SourceMap.Lookup(int(pc))
+	if !ok {
+		v.showInstruction()
+		return
+	}
+	where := mapping.Function
+	if mapping.File != "" {
+		where = strings.TrimPrefix(where+" at "+mapping.File+":"+strconv.Itoa(mapping.Line), " ")
+	}
+	if v.starts[pc] {
+		fmt.Fprintf(v.out, "%s: %s\n", where, mapping.Command)
+	} else {
+		fmt.Fprintf(v.out, "%s: %s, at ROM[%d]: %s\n", where, mapping.Command, pc, v.disassemble(pc))
+	}
+}
+
+// frames reconstructs the call stack from the frames saved by calls, from the current function to the outermost.
+func (v *VMDebugger) frames() []frame {
+	c := v.Computer
+	mapping, _ := v.SourceMap.Lookup(int(c.PC))
+	current := frame{function: mapping.Function, pc: c.PC, lcl: c.RAM[LCL], arg: c.RAM[ARG]}
+	frames := []frame{current}
+	// Each call saved the return address, LCL and ARG of the caller below the callee's LCL.
+	for f := &frames[0]; f.lcl >= 5 && len(frames) < maxFrames; f = &frames[len(frames)-1] {
+		returnAddress := c.RAM[f.lcl-5]
+		call, ok := v.SourceMap.Lookup(int(returnAddress) - 1)
+		if !ok || !strings.HasPrefix(call.Command, "call ") && call.Command != "bootstrap" {
+			break
+		}
+		f.args = max(int(f.lcl)-5-int(f.arg), 0)
+		if call.Command == "bootstrap" {
+			break
+		}
+		frames = append(frames, frame{function: call.Function, pc: returnAddress - 1, lcl: c.RAM[f.lcl-4], arg: c.RAM[f.lcl-3]})
+	}
+	return frames
+}
+
+// describeFrame shows a frame as its function applied to its arguments, and the VM command executed.
+func (v *VMDebugger) describeFrame(f frame) string {
+	var args []string
+	for j := range f.args {
+		args = append(args, strconv.Itoa(int(int16(v.Computer.RAM[(f.arg+uint16(j))&(emulator.RAMSize-1)]))))
+	}
+	mapping, _ := v.SourceMap.Lookup(int(f.pc))
+	name := f.function
+	if name == "" {
+		name = "(no function)"
+	}
+	if mapping.File == "" {
+		return fmt.Sprintf("%s(%s): %s", name, strings.Join(args, ", "), mapping.Command)
+	}
+	return fmt.Sprintf("%s(%s) at %s:%d: %s", name, strings.Join(args, ", "), mapping.File, mapping.Line, mapping.Command)
+}
+
+// stackBase returns the address of the bottom of the working stack of a frame, after its locals.
+func (v *VMDebugger) stackBase(f frame) uint16 {
+	if f.function == "" {
+		return vm.DefaultStackBase
+	}
+	return f.lcl + uint16(v.functions[f.function].locals)
+}
+
+func (v *VMDebugger) showWord(name string, address uint16) {
+	address &= emulator.RAMSize - 1
+	fmt.Fprintf(v.out, "%s = %d (RAM[%d])\n", name, int16(v.Computer.RAM[address]), address)
+}
+
+// vmAddress returns the ROM address of a function, of the first command at or after a line of a VM file, or of a label or number.
+func (v *VMDebugger) vmAddress(s string) (uint16, error) {
+	if f, ok := v.functions[s]; ok {
+		return f.address, nil
+	}
+	file, lineText, ok := strings.Cut(s, ".vm:")
+	if !ok {
+		return v.romAddress(s)
+	}
+	file += ".vm"
+	line, err := strconv.Atoi(lineText)
+	if err != nil || line < 1 {
+		return 0, fmt.Errorf("invalid line %q", lineText)
+	}
+	best := -1
+	for i, m := range v.SourceMap.Mappings {
+		if m.File == file && m.Line >= line && (best < 0 || m.Line < v.SourceMap.Mappings[best].Line) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0, fmt.Errorf("no VM command at or after %s:%d", file, line)
+	}
+	return uint16(v.SourceMap.Mappings[best].ROMAddress), nil
+}
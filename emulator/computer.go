@@ -0,0 +1,203 @@
+package emulator
+
+import "github.com/benjaminclauss/nand2tetris/assembler"
+
+// Sizes and memory map of the Hack platform.
+const (
+	ROMSize    = 32768
+	RAMSize    = 32768
+	Screen     = 16384
+	ScreenSize = 8192
+	Keyboard   = 24576
+)
+
+// A Computer emulates the Hack computer: a CPU executing the program in its ROM, and a RAM including the screen and keyboard memory maps.
+type Computer struct {
+	ROM []uint16
+	RAM []uint16
+
+	A, D, PC uint16
+	// Cycle counts the instructions executed since the last reset.
+	Cycle uint64
+	// Input, if not nil, replays key events into the keyboard memory map.
+	Input *KeyboardInput
+	// History, if not nil, records the instructions executed by Step so that StepBack can undo them.
+	History *History
+
+	// code is the predecoded ROM executed by Run.
+	code []decoded
+	// preload holds the RAM words written again by Reset.
+	preload []assembler.RAMWord
+}
+
+// The Effect of an executed instruction, as seen on the outputs of the CPU.
+type Effect struct {
+	// PC is the address of the instruction executed.
+	PC          uint16
+	Instruction uint16
+	// WriteM tells whether OutM was written to RAM[AddressM], whose previous value was OldM.
+	WriteM bool
+	// AddressM is the RAM address in A, without its most significant bit, as on the 15-bit addressM output of the CPU.
+	AddressM uint16
+	OutM     uint16
+	OldM     uint16
+}
+
+// NewComputer creates a computer with the program loaded in its ROM, ready to run from address 0.
+func NewComputer(program []uint16) *Computer {
+	c := &Computer{ROM: make([]uint16, ROMSize), RAM: make([]uint16, RAMSize)}
+	copy(c.ROM, program)
+	c.Predecode()
+	return c
+}
+
+// Reset restarts the program from address 0, with cleared registers and RAM holding only the preloaded words,
+// replays key events from the start and forgets History.
+func (c *Computer) Reset() {
+	clear(c.RAM)
+	c.writeRAM(c.preload)
+	c.A, c.D, c.PC, c.Cycle = 0, 0, 0, 0
+	if c.Input != nil {
+		c.Input.next, c.Input.releaseAt = 0, 0
+	}
+	if c.History != nil {
+		c.History.Clear()
+	}
+}
+
+// Preload writes words into RAM before the first cycle, such as those of a RAM image written by the assembler.
+// Reset writes them again.
+func (c *Computer) Preload(words []assembler.RAMWord) {
+	c.preload = append(c.preload, words...)
+	c.writeRAM(words)
+}
+
+func (c *Computer) writeRAM(words []assembler.RAMWord) {
+	for _, word := range words {
+		c.RAM[word.Address&(RAMSize-1)] = word.Value
+	}
+}
+
+// Step executes the instruction at PC.
+func (c *Computer) Step() Effect {
+	if c.History == nil {
+		return c.step()
+	}
+	entry := HistoryEntry{Cycle: c.Cycle, A: c.A, D: c.D, keyboard: c.RAM[Keyboard]}
+	if c.Input != nil {
+		entry.next, entry.releaseAt = c.Input.next, c.Input.releaseAt
+	}
+	entry.Effect = c.step()
+	c.History.push(entry)
+	return entry.Effect
+}
+
+func (c *Computer) step() Effect {
+	if c.Input != nil {
+		c.Input.update(c)
+	}
+	instruction := c.ROM[c.PC]
+	effect := Effect{PC: c.PC, Instruction: instruction, AddressM: c.A & (RAMSize - 1)}
+	c.Cycle++
+	if instruction&0x8000 == 0 {
+		c.A = instruction
+		c.PC = (c.PC + 1) & (ROMSize - 1)
+		return effect
+	}
+
+	y := c.A
+	if instruction&0x1000 != 0 {
+		y = c.RAM[c.A&(RAMSize-1)]
+	}
+	out := ALU(c.D, y, instruction>>6&0x3F)
+	effect.OutM = out
+
+	// The destinations are written with the output computed from the previous registers, and the jump targets the previous A.
+	target := c.A
+	if instruction&0x08 != 0 {
+		effect.WriteM, effect.OldM = true, c.RAM[effect.AddressM]
+		c.RAM[effect.AddressM] = out
+	}
+	if instruction&0x20 != 0 {
+		c.A = out
+	}
+	if instruction&0x10 != 0 {
+		c.D = out
+	}
+	if Jumps(out, instruction&0x07) {
+		c.PC = target & (ROMSize - 1)
+	} else {
+		c.PC = (c.PC + 1) & (ROMSize - 1)
+	}
+	return effect
+}
+
+// ALU computes the output of the Hack ALU for inputs x and y and the control bits zx nx zy ny f no, from the most significant.
+func ALU(x, y uint16, control uint16) uint16 {
+	if control&0x20 != 0 {
+		x = 0
+	}
+	if control&0x10 != 0 {
+		x = ^x
+	}
+	if control&0x08 != 0 {
+		y = 0
+	}
+	if control&0x04 != 0 {
+		y = ^y
+	}
+	var out uint16
+	if control&0x02 != 0 {
+		out = x + y
+	} else {
+		out = x & y
+	}
+	if control&0x01 != 0 {
+		out = ^out
+	}
+	return out
+}
+
+// Jumps tells whether the jump bits j1 j2 j3 of a C-instruction are satisfied by the ALU output out.
+func Jumps(out uint16, jump uint16) bool {
+	value := int16(out)
+	return (jump&0x04 != 0 && value < 0) || (jump&0x02 != 0 && value == 0) || (jump&0x01 != 0 && value > 0)
+}
+
+// Halted tells whether the program has reached the conventional end of a Hack program,
+// an infinite loop of an A-instruction addressing itself followed by an unconditional jump.
+func (c *Computer) Halted() bool {
+	next := c.ROM[(c.PC+1)&(ROMSize-1)]
+	return c.ROM[c.PC] == c.PC && next&0xE007 == 0xE007
+}
+
+// Run executes instructions until the program halts or n instructions have been executed, and tells whether it halted.
+// It executes the predecoded ROM, much faster than repeated calls to Step, unless History records the instructions.
+func (c *Computer) Run(n uint64) bool {
+	if c.History != nil {
+		for range n {
+			if c.Halted() {
+				return true
+			}
+			c.Step()
+		}
+		return c.Halted()
+	}
+	if len(c.code) != ROMSize {
+		c.Predecode()
+	}
+	for n > 0 {
+		batch := n
+		if c.Input != nil {
+			// Key events are replayed between batches.
+			c.Input.update(c)
+			batch = min(batch, c.Input.due()-c.Cycle)
+		}
+		executed := c.execute(batch)
+		n -= executed
+		if executed < batch {
+			break
+		}
+	}
+	return c.Halted()
+}
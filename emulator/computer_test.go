@@ -0,0 +1,92 @@
+package emulator_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/benjaminclauss/nand2tetris/command"
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+// pong assembles the Pong game, which never halts.
+func pong(tb testing.TB) []uint16 {
+	tb.Helper()
+	source, err := os.Open(filepath.Join("..", "6", "test", "pong", "Pong.asm"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer source.Close()
+	var binary bytes.Buffer
+	if _, err := command.Assemble(source, &binary); err != nil {
+		tb.Fatal(err)
+	}
+	program, err := emulator.ReadProgram(&binary)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return program
+}
+
+// pongKeys moves the paddle left, then right, so that the game takes both branches of its input loop.
+// Pong reads the keyboard once it has drawn its screen, about 4 million cycles after starting.
+var pongKeys = []emulator.KeyEvent{
+	{Cycle: 5_000_000, Key: emulator.KeyLeft, Hold: 1_000_000},
+	{Cycle: 6_500_000, Key: emulator.KeyRight},
+	{Cycle: 7_500_000, Key: 0},
+}
+
+func TestRunMatchesStep(t *testing.T) {
+	const cycles = 8_000_000
+	program := pong(t)
+	for _, keys := range [][]emulator.KeyEvent{nil, pongKeys} {
+		stepped := emulator.NewComputer(program)
+		run := emulator.NewComputer(program)
+		if keys != nil {
+			stepped.Input = emulator.NewKeyboardInput(keys)
+			run.Input = emulator.NewKeyboardInput(keys)
+		}
+		for range cycles {
+			stepped.Step()
+		}
+		// Runs of uneven lengths end batches between key events as well as at them.
+		for remaining := uint64(cycles); remaining > 0; {
+			n := min(remaining, 123_457)
+			run.Run(n)
+			remaining -= n
+		}
+
+		checkSameState(t, fmt.Sprintf("with %d key events, Run", len(keys)), run, stepped)
+	}
+}
+
+// checkSameState reports the first difference between the registers, cycle and RAM of got and want.
+func checkSameState(t *testing.T, name string, got, want *emulator.Computer) {
+	t.Helper()
+	if got.PC != want.PC || got.A != want.A || got.D != want.D || got.Cycle != want.Cycle {
+		t.Errorf("%s ended at PC=%d A=%d D=%d cycle %d, want PC=%d A=%d D=%d cycle %d",
+			name, got.PC, got.A, got.D, got.Cycle, want.PC, want.A, want.D, want.Cycle)
+	}
+	for i := range got.RAM {
+		if got.RAM[i] != want.RAM[i] {
+			t.Errorf("%s ended with RAM[%d] = %d, want %d", name, i, got.RAM[i], want.RAM[i])
+			return
+		}
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	computer := emulator.NewComputer(pong(b))
+	b.ResetTimer()
+	for range b.N {
+		computer.Step()
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	computer := emulator.NewComputer(pong(b))
+	b.ResetTimer()
+	computer.Run(uint64(b.N))
+}
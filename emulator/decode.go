@@ -0,0 +1,192 @@
+package emulator
+
+// Operations of predecoded instructions: loading A, the computations of the Hack instruction set,
+// and the generic ALU for the control bits outside it.
+const (
+	opLoad uint8 = iota
+	opZero
+	opOne
+	opMinusOne
+	opD
+	opY
+	opNotD
+	opNotY
+	opMinusD
+	opMinusY
+	opDPlusOne
+	opYPlusOne
+	opDMinusOne
+	opYMinusOne
+	opDPlusY
+	opDMinusY
+	opYMinusD
+	opDAndY
+	opDOrY
+	opALU
+)
+
+// The operations of the computations of the Hack instruction set, by ALU control bits, y being A or M.
+var operations = map[uint16]uint8{
+	0x2A: opZero,
+	0x3F: opOne,
+	0x3A: opMinusOne,
+	0x0C: opD,
+	0x30: opY,
+	0x0D: opNotD,
+	0x31: opNotY,
+	0x0F: opMinusD,
+	0x33: opMinusY,
+	0x1F: opDPlusOne,
+	0x37: opYPlusOne,
+	0x0E: opDMinusOne,
+	0x32: opYMinusOne,
+	0x02: opDPlusY,
+	0x13: opDMinusY,
+	0x07: opYMinusD,
+	0x00: opDAndY,
+	0x15: opDOrY,
+}
+
+// Destination bits of predecoded instructions.
+const (
+	destM uint8 = 1 << iota
+	destD
+	destA
+)
+
+// Jump conditions of predecoded instructions, matching the j1 j2 j3 bits.
+const (
+	jumpGT uint8 = 1 << iota
+	jumpEQ
+	jumpLT
+)
+
+// A decoded instruction, holding what executing it needs without extracting fields from the instruction bits.
+type decoded struct {
+	// value is loaded into A by A-instructions.
+	value uint16
+	op    uint8
+	// control holds the ALU control bits for opALU.
+	control uint8
+	dest    uint8
+	jump    uint8
+	// readM tells whether the y input of the ALU is M rather than A.
+	readM bool
+	// halts tells whether the instruction begins the infinite loop ending the program, as reported by Halted.
+	halts bool
+}
+
+// Predecode decodes the ROM for Run. It is called by NewComputer, and must be called again if the ROM changes.
+func (c *Computer) Predecode() {
+	if len(c.code) != ROMSize {
+		c.code = make([]decoded, ROMSize)
+	}
+	for address, instruction := range c.ROM {
+		next := c.ROM[(address+1)&(ROMSize-1)]
+		in := decoded{halts: int(instruction) == address && next&0xE007 == 0xE007}
+		if instruction&0x8000 == 0 {
+			in.op, in.value = opLoad, instruction
+		} else {
+			control := instruction >> 6 & 0x3F
+			op, ok := operations[control]
+			if !ok {
+				op = opALU
+			}
+			in.op, in.control = op, uint8(control)
+			in.dest = uint8(instruction>>3) & 0x07
+			in.jump = uint8(instruction) & 0x07
+			in.readM = instruction&0x1000 != 0
+		}
+		c.code[address] = in
+	}
+}
+
+// execute runs the predecoded program for at most n instructions, stopping before an instruction that halts,
+// and returns the number of instructions executed.
+func (c *Computer) execute(n uint64) uint64 {
+	code, ram := c.code[:ROMSize], c.RAM[:RAMSize]
+	a, d, pc := c.A, c.D, c.PC
+	var executed uint64
+	for ; executed < n; executed++ {
+		in := &code[pc&(ROMSize-1)]
+		if in.halts {
+			break
+		}
+		if in.op == opLoad {
+			a = in.value
+			pc = (pc + 1) & (ROMSize - 1)
+			continue
+		}
+		y := a
+		if in.readM {
+			y = ram[a&(RAMSize-1)]
+		}
+		var out uint16
+		switch in.op {
+		case opZero:
+			out = 0
+		case opOne:
+			out = 1
+		case opMinusOne:
+			out = 0xFFFF
+		case opD:
+			out = d
+		case opY:
+			out = y
+		case opNotD:
+			out = ^d
+		case opNotY:
+			out = ^y
+		case opMinusD:
+			out = -d
+		case opMinusY:
+			out = -y
+		case opDPlusOne:
+			out = d + 1
+		case opYPlusOne:
+			out = y + 1
+		case opDMinusOne:
+			out = d - 1
+		case opYMinusOne:
+			out = y - 1
+		case opDPlusY:
+			out = d + y
+		case opDMinusY:
+			out = d - y
+		case opYMinusD:
+			out = y - d
+		case opDAndY:
+			out = d & y
+		case opDOrY:
+			out = d | y
+		default:
+			out = ALU(d, y, uint16(in.control))
+		}
+
+		// As in Step, the destinations get the output computed from the previous registers, and the jump targets the previous A.
+		target := a
+		if in.dest&destM != 0 {
+			ram[a&(RAMSize-1)] = out
+		}
+		if in.dest&destA != 0 {
+			a = out
+		}
+		if in.dest&destD != 0 {
+			d = out
+		}
+		condition := jumpGT
+		if int16(out) < 0 {
+			condition = jumpLT
+		} else if out == 0 {
+			condition = jumpEQ
+		}
+		if in.jump&condition != 0 {
+			pc = target & (ROMSize - 1)
+		} else {
+			pc = (pc + 1) & (ROMSize - 1)
+		}
+	}
+	c.A, c.D, c.PC = a, d, pc
+	c.Cycle += executed
+	return executed
+}
@@ -0,0 +1,113 @@
+package emulator
+
+// A History is a bounded undo log of the instructions executed by a computer, with which it can run backwards.
+// Once full, the oldest instructions are forgotten.
+type History struct {
+	// entries is a ring buffer, which grows as instructions are recorded until it holds size entries.
+	entries []HistoryEntry
+	size    int
+	// start is the index of the oldest entry, and n the number of entries.
+	start, n int
+}
+
+// initialHistoryEntries is the capacity of a new history, doubled as it fills.
+const initialHistoryEntries = 1024
+
+// A HistoryEntry records an executed instruction and the state it changed.
+type HistoryEntry struct {
+	// Cycle is the cycle count before the instruction.
+	Cycle uint64
+	Effect
+	// A and D are the registers before the instruction.
+	A, D uint16
+
+	// The keyboard memory map and the replay position of Input before the instruction, which may have changed them.
+	keyboard  uint16
+	next      int
+	releaseAt uint64
+}
+
+// NewHistory creates a history of the last size instructions executed.
+// Its memory grows with the instructions recorded, up to size entries.
+func NewHistory(size int) *History {
+	size = max(size, 0)
+	return &History{entries: make([]HistoryEntry, min(size, initialHistoryEntries)), size: size}
+}
+
+// Len returns the number of instructions recorded.
+func (h *History) Len() int {
+	return h.n
+}
+
+// Entry returns the entry of the ith most recent instruction, 0 being the last instruction executed.
+func (h *History) Entry(i int) HistoryEntry {
+	return h.entries[(h.start+h.n-1-i)%len(h.entries)]
+}
+
+// LastWrite returns the index for Entry of the most recent instruction that wrote RAM[address].
+func (h *History) LastWrite(address uint16) (int, bool) {
+	for i := range h.n {
+		if e := h.Entry(i); e.WriteM && e.AddressM == address {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Clear forgets the instructions recorded.
+func (h *History) Clear() {
+	h.start, h.n = 0, 0
+}
+
+func (h *History) push(e HistoryEntry) {
+	if len(h.entries) == 0 {
+		return
+	}
+	if h.n == len(h.entries) && h.n < h.size {
+		h.grow()
+	}
+	if h.n == len(h.entries) {
+		h.entries[h.start] = e
+		h.start = (h.start + 1) % len(h.entries)
+		return
+	}
+	h.entries[(h.start+h.n)%len(h.entries)] = e
+	h.n++
+}
+
+// grow doubles the capacity of the full ring, up to its size. The ring only wraps around once it has reached its size,
+// so the oldest entry is still the first.
+func (h *History) grow() {
+	h.entries = append(h.entries, make([]HistoryEntry, min(len(h.entries), h.size-len(h.entries)))...)
+}
+
+func (h *History) pop() (HistoryEntry, bool) {
+	if h.n == 0 {
+		return HistoryEntry{}, false
+	}
+	e := h.Entry(0)
+	h.n--
+	return e, true
+}
+
+// StepBack undoes the last instruction recorded in History, and returns its entry.
+// It returns false if there is no instruction to undo.
+func (c *Computer) StepBack() (HistoryEntry, bool) {
+	if c.History == nil {
+		return HistoryEntry{}, false
+	}
+	e, ok := c.History.pop()
+	if !ok {
+		return e, false
+	}
+	// The write to M happened after any key event, so the keyboard is restored last.
+	if e.WriteM {
+		c.RAM[e.AddressM] = e.OldM
+	}
+	c.RAM[Keyboard] = e.keyboard
+	if c.Input != nil {
+		c.Input.next, c.Input.releaseAt = e.next, e.releaseAt
+	}
+	c.A, c.D, c.PC, c.Cycle = e.A, e.D, e.PC, e.Cycle
+	return e, true
+}
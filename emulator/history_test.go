@@ -0,0 +1,58 @@
+package emulator_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+func TestStepBack(t *testing.T) {
+	const (
+		// The steps cross the release of the left key at cycle 6,000,000.
+		start = 5_995_000
+		steps = 10_000
+		size  = 6_000
+	)
+	program := pong(t)
+	newComputer := func() *emulator.Computer {
+		computer := emulator.NewComputer(program)
+		computer.Input = emulator.NewKeyboardInput(pongKeys)
+		computer.Run(start)
+		return computer
+	}
+	// want holds the oldest state still recorded once the history has wrapped around.
+	want := newComputer()
+	want.Run(steps - size)
+	wantSnapshot := want.Snapshot()
+
+	computer := newComputer()
+	computer.History = emulator.NewHistory(size)
+	for range steps {
+		computer.Step()
+	}
+	end := computer.Snapshot()
+	if got := computer.History.Len(); got != size {
+		t.Fatalf("History.Len() = %d after %d steps, want %d", got, steps, size)
+	}
+	for i := range size {
+		if _, ok := computer.StepBack(); !ok {
+			t.Fatalf("StepBack %d of %d failed", i+1, size)
+		}
+	}
+	if _, ok := computer.StepBack(); ok {
+		t.Errorf("StepBack succeeded past the %d instructions recorded", size)
+	}
+	checkSameState(t, "stepping back", computer, want)
+	if got := computer.Snapshot(); !reflect.DeepEqual(got.Keyboard, wantSnapshot.Keyboard) {
+		t.Errorf("stepping back left the keyboard at %+v, want %+v", got.Keyboard, wantSnapshot.Keyboard)
+	}
+
+	// Stepping forward again replays the same instructions.
+	for range size {
+		computer.Step()
+	}
+	if got := computer.Snapshot(); !reflect.DeepEqual(got, end) {
+		t.Errorf("stepping forward after stepping back ended at cycle %d PC=%d, want cycle %d PC=%d", got.Cycle, got.PC, end.Cycle, end.PC)
+	}
+}
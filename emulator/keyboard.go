@@ -0,0 +1,160 @@
+package emulator
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Codes of the Hack character set for the keys that are not printable characters.
+// The function keys F1 to F12 are KeyF1 to KeyF1+11.
+const (
+	KeyNewline   = 128
+	KeyBackspace = 129
+	KeyLeft      = 130
+	KeyUp        = 131
+	KeyRight     = 132
+	KeyDown      = 133
+	KeyHome      = 134
+	KeyEnd       = 135
+	KeyPageUp    = 136
+	KeyPageDown  = 137
+	KeyInsert    = 138
+	KeyDelete    = 139
+	KeyEscape    = 140
+	KeyF1        = 141
+)
+
+var keyNames = map[string]uint16{
+	"none":      0,
+	"space":     ' ',
+	"newline":   KeyNewline,
+	"enter":     KeyNewline,
+	"backspace": KeyBackspace,
+	"left":      KeyLeft,
+	"up":        KeyUp,
+	"right":     KeyRight,
+	"down":      KeyDown,
+	"home":      KeyHome,
+	"end":       KeyEnd,
+	"pageup":    KeyPageUp,
+	"pagedown":  KeyPageDown,
+	"insert":    KeyInsert,
+	"delete":    KeyDelete,
+	"escape":    KeyEscape,
+	"esc":       KeyEscape,
+}
+
+// KeyCode returns the Hack character set code of a key given by name, such as left or F1, as a printable character, or as a number.
+func KeyCode(key string) (uint16, error) {
+	if code, ok := keyNames[strings.ToLower(key)]; ok {
+		return code, nil
+	}
+	if n, ok := strings.CutPrefix(strings.ToLower(key), "f"); ok {
+		if i, err := strconv.Atoi(n); err == nil && i >= 1 && i <= 12 {
+			return KeyF1 + uint16(i) - 1, nil
+		}
+	}
+	if code, err := strconv.ParseUint(key, 10, 16); err == nil {
+		return uint16(code), nil
+	}
+	if len(key) == 1 && key[0] > ' ' && key[0] < 0x7F {
+		return uint16(key[0]), nil
+	}
+	return 0, fmt.Errorf("unknown key %q", key)
+}
+
+// A KeyEvent presses a key once Cycle instructions have been executed.
+type KeyEvent struct {
+	Cycle uint64 `json:"cycle"`
+	Key   uint16 `json:"key"`
+	// Hold is the number of cycles after which the key is released, or 0 to hold it until the next event.
+	Hold uint64 `json:"hold,omitempty"`
+}
+
+// ParseKeyScript reads a keyboard script, one event per line as the cycle, the key and optionally the cycles to hold it:
+//
+//	# start the game, then move left for a while
+//	1000000 space 50000
+//	2000000 left 300000
+//
+// Keys are named as accepted by KeyCode, and # starts a comment. Events are returned in cycle order.
+func ParseKeyScript(r io.Reader) ([]KeyEvent, error) {
+	var events []KeyEvent
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 || len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a cycle, a key and optionally the cycles to hold it", n)
+		}
+		cycle, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid cycle %q", n, fields[0])
+		}
+		key, err := KeyCode(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		event := KeyEvent{Cycle: cycle, Key: key}
+		if len(fields) == 3 {
+			if event.Hold, err = strconv.ParseUint(fields[2], 10, 64); err != nil || event.Hold == 0 {
+				return nil, fmt.Errorf("line %d: invalid hold %q", n, fields[2])
+			}
+		}
+		events = append(events, event)
+	}
+	slices.SortStableFunc(events, func(a, b KeyEvent) int { return cmp.Compare(a.Cycle, b.Cycle) })
+	return events, scanner.Err()
+}
+
+// KeyboardInput replays key events into the keyboard memory map of a computer as it runs.
+type KeyboardInput struct {
+	Events []KeyEvent
+	// next is the index of the next event to replay.
+	next int
+	// releaseAt is the cycle at which the key pressed is released, or 0 if it is held.
+	releaseAt uint64
+}
+
+// NewKeyboardInput creates an input replaying events, which must be in cycle order.
+func NewKeyboardInput(events []KeyEvent) *KeyboardInput {
+	return &KeyboardInput{Events: events}
+}
+
+// update applies the events due before the next instruction of c.
+func (k *KeyboardInput) update(c *Computer) {
+	for k.next < len(k.Events) && k.Events[k.next].Cycle <= c.Cycle {
+		event := k.Events[k.next]
+		c.RAM[Keyboard] = event.Key
+		k.releaseAt = 0
+		if event.Hold > 0 {
+			k.releaseAt = event.Cycle + event.Hold
+		}
+		k.next++
+	}
+	if k.releaseAt != 0 && c.Cycle >= k.releaseAt {
+		c.RAM[Keyboard] = 0
+		k.releaseAt = 0
+	}
+}
+
+// due returns the cycle at which the next event or key release is due, which is after the current cycle once updated.
+func (k *KeyboardInput) due() uint64 {
+	due := uint64(math.MaxUint64)
+	if k.next < len(k.Events) {
+		due = k.Events[k.next].Cycle
+	}
+	if k.releaseAt != 0 {
+		due = min(due, k.releaseAt)
+	}
+	return due
+}
@@ -0,0 +1,33 @@
+package emulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadProgram reads a Hack binary program, one 16-character string of 0s and 1s per line.
+func ReadProgram(r io.Reader) ([]uint16, error) {
+	var program []uint16
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(line) != 16 {
+			return nil, fmt.Errorf("line %d: expected 16 binary digits, got %q", n, line)
+		}
+		instruction, err := strconv.ParseUint(line, 2, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: expected 16 binary digits, got %q", n, line)
+		}
+		if len(program) == ROMSize {
+			return nil, fmt.Errorf("line %d: program exceeds the %d words of ROM", n, ROMSize)
+		}
+		program = append(program, uint16(instruction))
+	}
+	return program, scanner.Err()
+}
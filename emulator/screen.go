@@ -0,0 +1,145 @@
+package emulator
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// Dimensions of the screen in pixels. Each row is 32 words, whose least significant bit is the leftmost pixel.
+const (
+	ScreenWidth  = 512
+	ScreenHeight = 256
+)
+
+// Styles of terminal rendering.
+const (
+	// StyleBraille draws 2x4 pixels per character with Unicode braille patterns.
+	StyleBraille = "braille"
+	// StyleBlocks draws 1x2 pixels per character with half block characters.
+	StyleBlocks = "blocks"
+)
+
+// Pixel tells whether the pixel at column x and row y of the screen memory map of ram is black.
+func Pixel(ram []uint16, x, y int) bool {
+	return ram[Screen+y*ScreenWidth/16+x/16]&(1<<(x%16)) != 0
+}
+
+// ScreenImage returns the screen memory map of ram as a black and white image.
+func ScreenImage(ram []uint16) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, ScreenWidth, ScreenHeight), color.Palette{color.White, color.Black})
+	for y := range ScreenHeight {
+		for x := range ScreenWidth {
+			if Pixel(ram, x, y) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// WriteScreenPNG encodes the screen memory map of ram as a PNG image.
+func WriteScreenPNG(w io.Writer, ram []uint16) error {
+	return png.Encode(w, ScreenImage(ram))
+}
+
+// ReadScreenPNG decodes a 512x256 PNG image into screen memory, with dark pixels black.
+// It returns the ScreenSize words of the memory map.
+func ReadScreenPNG(r io.Reader) ([]uint16, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() != ScreenWidth || bounds.Dy() != ScreenHeight {
+		return nil, fmt.Errorf("image is %dx%d, not %dx%d", bounds.Dx(), bounds.Dy(), ScreenWidth, ScreenHeight)
+	}
+	words := make([]uint16, ScreenSize)
+	for y := range ScreenHeight {
+		for x := range ScreenWidth {
+			if gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray); gray.Y < 128 {
+				words[y*ScreenWidth/16+x/16] |= 1 << (x % 16)
+			}
+		}
+	}
+	return words, nil
+}
+
+// ScreenDiff returns the number of pixels that differ between the screen memory map of ram and the screen words of want,
+// as returned by ReadScreenPNG.
+func ScreenDiff(ram []uint16, want []uint16) int {
+	diff := 0
+	for i, word := range want {
+		for bits := ram[Screen+i] ^ word; bits != 0; bits &= bits - 1 {
+			diff++
+		}
+	}
+	return diff
+}
+
+// RenderScreen draws the screen memory map of ram as text in the given style.
+// With a scale greater than 1, each character covers blocks of scale x scale pixels, which are black if any of their pixels is.
+func RenderScreen(w io.Writer, ram []uint16, style string, scale int) error {
+	scale = max(scale, 1)
+	width, height := ScreenWidth/scale, ScreenHeight/scale
+	black := func(x, y int) bool {
+		if x >= width || y >= height {
+			return false
+		}
+		for dy := range scale {
+			for dx := range scale {
+				if Pixel(ram, x*scale+dx, y*scale+dy) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	var cellWidth, cellHeight int
+	var cell func(x, y int) rune
+	switch style {
+	case StyleBraille:
+		// Dots are numbered down the left column then down the right column, with the bottom row last.
+		dots := [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+		cellWidth, cellHeight = 2, 4
+		cell = func(x, y int) rune {
+			r := rune(0x2800)
+			for dy := range 4 {
+				for dx := range 2 {
+					if black(x+dx, y+dy) {
+						r |= dots[dy][dx]
+					}
+				}
+			}
+			return r
+		}
+	case StyleBlocks:
+		cellWidth, cellHeight = 1, 2
+		cell = func(x, y int) rune {
+			return []rune{' ', '▀', '▄', '█'}[boolIndex(black(x, y))|boolIndex(black(x, y+1))<<1]
+		}
+	default:
+		return fmt.Errorf("unknown screen style %q", style)
+	}
+
+	var text strings.Builder
+	for y := 0; y < height; y += cellHeight {
+		for x := 0; x < width; x += cellWidth {
+			text.WriteRune(cell(x, y))
+		}
+		text.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, text.String())
+	return err
+}
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
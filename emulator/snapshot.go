@@ -0,0 +1,108 @@
+package emulator
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SnapshotVersion is the version of the snapshot format written by WriteSnapshot.
+const SnapshotVersion = 1
+
+// A Snapshot is the state of a computer, from which a run can be resumed later with the same program.
+type Snapshot struct {
+	Version int `json:"version"`
+	// ROMHash identifies the program, as returned by ROMHash.
+	ROMHash string   `json:"romHash"`
+	A       uint16   `json:"a"`
+	D       uint16   `json:"d"`
+	PC      uint16   `json:"pc"`
+	Cycle   uint64   `json:"cycle"`
+	RAM     []uint16 `json:"ram"`
+	// Keyboard is the state of the key events replayed, if any.
+	Keyboard *KeyboardSnapshot `json:"keyboard,omitempty"`
+}
+
+// A KeyboardSnapshot is the state of a KeyboardInput: its whole script, so that Reset can replay it from the start,
+// the index of the next event to replay and the pending key release.
+type KeyboardSnapshot struct {
+	Events    []KeyEvent `json:"events"`
+	Next      int        `json:"next"`
+	ReleaseAt uint64     `json:"releaseAt,omitempty"`
+}
+
+// ErrROMMismatch reports a snapshot taken with another program than the one loaded.
+var ErrROMMismatch = errors.New("snapshot was taken with a different program")
+
+// ROMHash returns the SHA-256 digest of a ROM in hexadecimal, with its words in little-endian order.
+func ROMHash(rom []uint16) string {
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, rom)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Snapshot returns the state of c.
+func (c *Computer) Snapshot() *Snapshot {
+	s := &Snapshot{
+		Version: SnapshotVersion,
+		ROMHash: ROMHash(c.ROM),
+		A:       c.A,
+		D:       c.D,
+		PC:      c.PC,
+		Cycle:   c.Cycle,
+		RAM:     append([]uint16(nil), c.RAM...),
+	}
+	if c.Input != nil {
+		s.Keyboard = &KeyboardSnapshot{
+			Events:    append([]KeyEvent{}, c.Input.Events...),
+			Next:      c.Input.next,
+			ReleaseAt: c.Input.releaseAt,
+		}
+	}
+	return s
+}
+
+// Restore sets the state of c to a snapshot taken with the same program, forgetting its History.
+func (c *Computer) Restore(s *Snapshot) error {
+	if s.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	if s.ROMHash != ROMHash(c.ROM) {
+		return ErrROMMismatch
+	}
+	if len(s.RAM) != RAMSize {
+		return fmt.Errorf("snapshot RAM holds %d words instead of %d", len(s.RAM), RAMSize)
+	}
+	if s.Keyboard != nil && (s.Keyboard.Next < 0 || s.Keyboard.Next > len(s.Keyboard.Events)) {
+		return fmt.Errorf("snapshot keyboard replays event %d of %d", s.Keyboard.Next, len(s.Keyboard.Events))
+	}
+	copy(c.RAM, s.RAM)
+	c.A, c.D, c.PC, c.Cycle = s.A, s.D, s.PC&(ROMSize-1), s.Cycle
+	c.Input = nil
+	if s.Keyboard != nil {
+		c.Input = NewKeyboardInput(append([]KeyEvent(nil), s.Keyboard.Events...))
+		c.Input.next, c.Input.releaseAt = s.Keyboard.Next, s.Keyboard.ReleaseAt
+	}
+	if c.History != nil {
+		c.History.Clear()
+	}
+	return nil
+}
+
+// WriteSnapshot encodes s as JSON to w.
+func WriteSnapshot(w io.Writer, s *Snapshot) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+// ReadSnapshot decodes a JSON snapshot written by WriteSnapshot.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
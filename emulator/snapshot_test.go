@@ -0,0 +1,65 @@
+package emulator_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	program := pong(t)
+	original := emulator.NewComputer(program)
+	original.Input = emulator.NewKeyboardInput(pongKeys)
+	// The snapshot is taken while the left key is held, after the first event and before its release.
+	original.Run(5_500_000)
+
+	var saved bytes.Buffer
+	if err := emulator.WriteSnapshot(&saved, original.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := emulator.ReadSnapshot(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := emulator.NewComputer(program)
+	if err := restored.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	checkSameState(t, "restoring", restored, original)
+
+	original.Run(2_500_000)
+	restored.Run(2_500_000)
+	checkSameState(t, "running after restoring", restored, original)
+
+	// Resetting replays the whole script, including the events consumed before the snapshot.
+	original.Reset()
+	restored.Reset()
+	original.Run(8_000_000)
+	restored.Run(8_000_000)
+	checkSameState(t, "running after resetting", restored, original)
+}
+
+func TestSnapshotROMMismatch(t *testing.T) {
+	program := pong(t)
+	snapshot := emulator.NewComputer(program).Snapshot()
+
+	other := append([]uint16(nil), program...)
+	other[0]++
+	computer := emulator.NewComputer(other)
+	if err := computer.Restore(snapshot); !errors.Is(err, emulator.ErrROMMismatch) {
+		t.Errorf("restoring a snapshot of another program: %v, want %v", err, emulator.ErrROMMismatch)
+	}
+}
+
+func TestSnapshotInvalidKeyboard(t *testing.T) {
+	program := pong(t)
+	computer := emulator.NewComputer(program)
+	computer.Input = emulator.NewKeyboardInput(pongKeys)
+	snapshot := computer.Snapshot()
+	snapshot.Keyboard.Next = len(pongKeys) + 1
+	if err := emulator.NewComputer(program).Restore(snapshot); err == nil {
+		t.Errorf("restoring a snapshot replaying event %d of %d succeeded", snapshot.Keyboard.Next, len(pongKeys))
+	}
+}
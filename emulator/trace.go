@@ -0,0 +1,180 @@
+package emulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/benjaminclauss/nand2tetris/assembler"
+)
+
+// A TraceRecord is the state of the CPU once an instruction has executed.
+type TraceRecord struct {
+	Cycle uint64
+	Effect
+	// A and D are the registers after the instruction.
+	A, D uint16
+}
+
+// A TraceWriter writes trace records to a file format.
+type TraceWriter interface {
+	Write(record TraceRecord) error
+	// Flush writes any buffered records.
+	Flush() error
+}
+
+// An AddressRange is the inclusive range of addresses from First to Last.
+type AddressRange struct {
+	First, Last uint16
+}
+
+// Contains tells whether the range contains address.
+func (r AddressRange) Contains(address uint16) bool {
+	return address >= r.First && address <= r.Last
+}
+
+// A TraceFilter selects the records worth tracing, keeping trace files manageable.
+// Unset fields select every record.
+type TraceFilter struct {
+	// From and To bound the cycles traced, To excluded if not 0.
+	From, To uint64
+	// PC selects the instructions at these ROM addresses.
+	PC []AddressRange
+	// Writes selects the instructions writing to these RAM addresses.
+	Writes []AddressRange
+}
+
+// Selects tells whether the filter selects a record.
+func (f *TraceFilter) Selects(record TraceRecord) bool {
+	if record.Cycle < f.From || f.To != 0 && record.Cycle >= f.To {
+		return false
+	}
+	if len(f.PC) > 0 && !anyContains(f.PC, record.PC) {
+		return false
+	}
+	return len(f.Writes) == 0 || record.WriteM && anyContains(f.Writes, record.AddressM)
+}
+
+func anyContains(ranges []AddressRange, address uint16) bool {
+	for _, r := range ranges {
+		if r.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunObserved runs like Run, calling observe with the record of each instruction executed.
+func (c *Computer) RunObserved(n uint64, observe func(TraceRecord) error) (bool, error) {
+	for range n {
+		if c.Halted() {
+			return true, nil
+		}
+		cycle := c.Cycle
+		effect := c.Step()
+		if err := observe(TraceRecord{cycle, effect, c.A, c.D}); err != nil {
+			return false, err
+		}
+	}
+	return c.Halted(), nil
+}
+
+// Trace returns an observer for RunObserved writing the records that filter selects to w.
+// Once the run is over, w must be flushed.
+func Trace(w TraceWriter, filter *TraceFilter) func(TraceRecord) error {
+	return func(record TraceRecord) error {
+		if filter.Selects(record) {
+			return w.Write(record)
+		}
+		return nil
+	}
+}
+
+// CSVTraceWriter writes records as lines of comma-separated values, after a header naming the columns.
+type CSVTraceWriter struct {
+	w             *bufio.Writer
+	headerWritten bool
+}
+
+// NewCSVTraceWriter creates a CSVTraceWriter writing to w.
+func NewCSVTraceWriter(w io.Writer) *CSVTraceWriter {
+	return &CSVTraceWriter{w: bufio.NewWriter(w)}
+}
+
+func (t *CSVTraceWriter) Write(r TraceRecord) error {
+	if !t.headerWritten {
+		t.w.WriteString("cycle,pc,instruction,assembly,a,d,writeM,addressM,outM\n")
+		t.headerWritten = true
+	}
+	writeM := 0
+	if r.WriteM {
+		writeM = 1
+	}
+	_, err := fmt.Fprintf(t.w, "%d,%d,%016b,%s,%d,%d,%d,%d,%d\n",
+		r.Cycle, r.PC, r.Instruction, assembler.Disassemble(r.Instruction), int16(r.A), int16(r.D), writeM, r.AddressM, int16(r.OutM))
+	return err
+}
+
+func (t *CSVTraceWriter) Flush() error {
+	return t.w.Flush()
+}
+
+// VCDTraceWriter writes records as a Value Change Dump waveform, as viewed with GTKWave, with one time unit per cycle.
+// Only the signals that changed since the previous record are written.
+type VCDTraceWriter struct {
+	w        *bufio.Writer
+	previous []uint16
+}
+
+// The signals of the VCD trace: name, width in bits and identifier code.
+var vcdSignals = []struct {
+	name  string
+	width int
+	id    string
+}{
+	{"pc", 15, "!"},
+	{"instruction", 16, "\""},
+	{"a", 16, "#"},
+	{"d", 16, "$"},
+	{"writeM", 1, "%"},
+	{"addressM", 15, "&"},
+	{"outM", 16, "'"},
+}
+
+// NewVCDTraceWriter creates a VCDTraceWriter writing to w.
+func NewVCDTraceWriter(w io.Writer) *VCDTraceWriter {
+	return &VCDTraceWriter{w: bufio.NewWriter(w)}
+}
+
+func (t *VCDTraceWriter) Write(r TraceRecord) error {
+	writeM := uint16(0)
+	if r.WriteM {
+		writeM = 1
+	}
+	values := []uint16{r.PC, r.Instruction, r.A, r.D, writeM, r.AddressM, r.OutM}
+	if t.previous == nil {
+		t.w.WriteString("$version nand2tetris $end\n$timescale 1 ns $end\n$scope module hack $end\n")
+		for _, s := range vcdSignals {
+			fmt.Fprintf(t.w, "$var wire %d %s %s $end\n", s.width, s.id, s.name)
+		}
+		t.w.WriteString("$upscope $end\n$enddefinitions $end\n")
+	}
+	t.w.WriteString("#" + strconv.FormatUint(r.Cycle, 10) + "\n")
+	for i, s := range vcdSignals {
+		if t.previous != nil && t.previous[i] == values[i] {
+			continue
+		}
+		if s.width == 1 {
+			t.w.WriteString(strconv.Itoa(int(values[i])) + s.id + "\n")
+		} else {
+			t.w.WriteString("b" + strconv.FormatUint(uint64(values[i]&(1<<s.width-1)), 2) + " " + s.id + "\n")
+		}
+	}
+	t.previous = values
+	return nil
+}
+
+func (t *VCDTraceWriter) Flush() error {
+	return t.w.Flush()
+}
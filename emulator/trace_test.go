@@ -0,0 +1,146 @@
+package emulator_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/benjaminclauss/nand2tetris/command"
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+// traceSource computes RAM[0] = 2 + 3, increments it and halts, in 7 instructions.
+const traceSource = `
+	@2
+	D=A
+	@3
+	D=D+A
+	@0
+	M=D
+	M=M+1
+(END)
+	@END
+	0;JMP
+`
+
+// trace runs traceSource to its end, writing the records that filter selects with w, and returns what w wrote.
+func trace(t *testing.T, newWriter func(*bytes.Buffer) emulator.TraceWriter, filter *emulator.TraceFilter) string {
+	t.Helper()
+	var binary bytes.Buffer
+	if _, err := command.Assemble(strings.NewReader(traceSource), &binary); err != nil {
+		t.Fatal(err)
+	}
+	program, err := emulator.ReadProgram(&binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	w := newWriter(&output)
+	halted, err := emulator.NewComputer(program).RunObserved(100, emulator.Trace(w, filter))
+	if err != nil || !halted {
+		t.Fatalf("RunObserved = %v, %v, want the program to halt", halted, err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return output.String()
+}
+
+func newCSV(b *bytes.Buffer) emulator.TraceWriter { return emulator.NewCSVTraceWriter(b) }
+
+func newVCD(b *bytes.Buffer) emulator.TraceWriter { return emulator.NewVCDTraceWriter(b) }
+
+func TestCSVTrace(t *testing.T) {
+	// addressM holds A before the instruction, outM the output of the ALU, which A-instructions leave at 0.
+	want := `cycle,pc,instruction,assembly,a,d,writeM,addressM,outM
+0,0,0000000000000010,@2,2,0,0,0,0
+1,1,1110110000010000,D=A,2,2,0,2,2
+2,2,0000000000000011,@3,3,2,0,2,0
+3,3,1110000010010000,D=D+A,3,5,0,3,5
+4,4,0000000000000000,@0,0,5,0,3,0
+5,5,1110001100001000,M=D,0,5,1,0,5
+6,6,1111110111001000,M=M+1,0,5,1,0,6
+`
+	if got := trace(t, newCSV, &emulator.TraceFilter{}); got != want {
+		t.Errorf("CSV trace:\n%s\nwant:\n%s", got, want)
+	}
+
+	want = `cycle,pc,instruction,assembly,a,d,writeM,addressM,outM
+5,5,1110001100001000,M=D,0,5,1,0,5
+6,6,1111110111001000,M=M+1,0,5,1,0,6
+`
+	if got := trace(t, newCSV, &emulator.TraceFilter{Writes: []emulator.AddressRange{{First: 0, Last: 0}}}); got != want {
+		t.Errorf("CSV trace of the writes to RAM[0]:\n%s\nwant:\n%s", got, want)
+	}
+	if got := trace(t, newCSV, &emulator.TraceFilter{From: 100}); got != "" {
+		t.Errorf("CSV trace without records:\n%s\nwant nothing, not even the header", got)
+	}
+}
+
+func TestVCDTrace(t *testing.T) {
+	// The header declares every signal with its width, then each time step lists only the signals that changed:
+	// writeM stays 1 at #6, and addressM stays 3 at #4.
+	want := `$version nand2tetris $end
+$timescale 1 ns $end
+$scope module hack $end
+$var wire 15 ! pc $end
+$var wire 16 " instruction $end
+$var wire 16 # a $end
+$var wire 16 $ d $end
+$var wire 1 % writeM $end
+$var wire 15 & addressM $end
+$var wire 16 ' outM $end
+$upscope $end
+$enddefinitions $end
+#0
+b0 !
+b10 "
+b10 #
+b0 $
+0%
+b0 &
+b0 '
+#1
+b1 !
+b1110110000010000 "
+b10 $
+b10 &
+b10 '
+#2
+b10 !
+b11 "
+b11 #
+b0 '
+#3
+b11 !
+b1110000010010000 "
+b101 $
+b11 &
+b101 '
+#4
+b100 !
+b0 "
+b0 #
+b0 '
+#5
+b101 !
+b1110001100001000 "
+1%
+b0 &
+b101 '
+#6
+b110 !
+b1111110111001000 "
+b110 '
+`
+	if got := trace(t, newVCD, &emulator.TraceFilter{}); got != want {
+		t.Errorf("VCD trace:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The first record selected lists every signal, at its own cycle.
+	want = "#5\nb101 !\nb1110001100001000 \"\nb0 #\nb101 $\n1%\nb0 &\nb101 '\n#6\nb110 !\nb1111110111001000 \"\nb110 '\n"
+	got := trace(t, newVCD, &emulator.TraceFilter{From: 5})
+	if _, records, _ := strings.Cut(got, "$enddefinitions $end\n"); records != want {
+		t.Errorf("VCD trace from cycle 5:\n%s\nwant the records:\n%s", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+// Package live runs Hack programs in real time, with their screen displayed in a terminal or a web page
+// and key presses forwarded to their keyboard.
+package live
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+// sliceDuration is the period at which the machine runs the instructions due at its clock rate.
+const sliceDuration = 10 * time.Millisecond
+
+// A Machine runs a computer at a clock rate, with its screen and keyboard safe for use by a display concurrently.
+type Machine struct {
+	// ClockRate is the number of instructions executed per second, or 0 to run as fast as possible.
+	ClockRate int
+
+	mu       sync.Mutex
+	computer *emulator.Computer
+	key      uint16
+	// release is the time at which the pressed key is released, or zero if it is held until Release.
+	release time.Time
+	// now returns the current time, which tests control.
+	now func() time.Time
+}
+
+// NewMachine creates a machine running computer at clockRate instructions per second.
+func NewMachine(computer *emulator.Computer, clockRate int) *Machine {
+	return &Machine{ClockRate: clockRate, computer: computer, now: time.Now}
+}
+
+// Run executes the program until ctx is done. Once the program halts, the machine idles.
+func (m *Machine) Run(ctx context.Context) {
+	ticker := time.NewTicker(sliceDuration)
+	defer ticker.Stop()
+	perSlice := uint64(m.ClockRate) * uint64(sliceDuration) / uint64(time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deadline := time.Now().Add(sliceDuration)
+		m.mu.Lock()
+		m.updateKeyboard()
+		if m.ClockRate > 0 {
+			m.computer.Run(max(perSlice, 1))
+		} else {
+			// Run in batches until the slice is over, letting the display in between.
+			for !m.computer.Halted() && time.Now().Before(deadline) {
+				m.computer.Run(10000)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+func (m *Machine) updateKeyboard() {
+	if !m.release.IsZero() && m.now().After(m.release) {
+		m.key, m.release = 0, time.Time{}
+	}
+	m.computer.RAM[emulator.Keyboard] = m.key
+}
+
+// Press presses a key of the Hack character set, held for hold or, if hold is 0, until Release.
+func (m *Machine) Press(key uint16, hold time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.key, m.release = key, time.Time{}
+	if hold > 0 {
+		m.release = m.now().Add(hold)
+	}
+}
+
+// Release releases the pressed key.
+func (m *Machine) Release() {
+	m.Press(0, 0)
+}
+
+// Screen copies the screen memory map into screen, which holds emulator.ScreenSize words.
+func (m *Machine) Screen(screen []uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copy(screen, m.computer.RAM[emulator.Screen:emulator.Screen+emulator.ScreenSize])
+}
+
+// Halted tells whether the program has halted.
+func (m *Machine) Halted() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.computer.Halted()
+}
@@ -0,0 +1,44 @@
+package live
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+func TestPress(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMachine(emulator.NewComputer(nil), 0)
+	m.now = func() time.Time { return now }
+	keyboard := func(when string, want uint16) {
+		t.Helper()
+		m.updateKeyboard()
+		if got := m.computer.RAM[emulator.Keyboard]; got != want {
+			t.Errorf("%s: keyboard = %d, want %d", when, got, want)
+		}
+	}
+
+	m.Press('A', 0)
+	now = now.Add(time.Hour)
+	keyboard("an hour after pressing a key held until released", 'A')
+	m.Release()
+	keyboard("after releasing it", 0)
+
+	m.Press(emulator.KeyLeft, 150*time.Millisecond)
+	keyboard("when pressing a key held for 150ms", emulator.KeyLeft)
+	now = now.Add(150 * time.Millisecond)
+	keyboard("150ms later", emulator.KeyLeft)
+	now = now.Add(time.Millisecond)
+	keyboard("151ms later", 0)
+
+	// Pressing another key, as a terminal repeating a held key does, restarts the hold.
+	m.Press(emulator.KeyLeft, 150*time.Millisecond)
+	now = now.Add(100 * time.Millisecond)
+	m.Press(emulator.KeyRight, 150*time.Millisecond)
+	now = now.Add(100 * time.Millisecond)
+	keyboard("100ms after pressing another key", emulator.KeyRight)
+	m.Press('A', 0)
+	now = now.Add(time.Hour)
+	keyboard("after pressing a key held until released over a held one", 'A')
+}
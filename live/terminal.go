@@ -0,0 +1,140 @@
+package live
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+// DefaultKeyHold is how long a key typed in a terminal stays pressed, since terminals do not report key releases.
+// Holding a key down repeats it before this delay expires.
+const DefaultKeyHold = 150 * time.Millisecond
+
+// Escape sequences of the keys of the Hack character set sent by terminals.
+var escapeSequences = map[string]uint16{
+	"[A": emulator.KeyUp, "[B": emulator.KeyDown, "[C": emulator.KeyRight, "[D": emulator.KeyLeft,
+	"OA": emulator.KeyUp, "OB": emulator.KeyDown, "OC": emulator.KeyRight, "OD": emulator.KeyLeft,
+	"[H": emulator.KeyHome, "[F": emulator.KeyEnd, "OH": emulator.KeyHome, "OF": emulator.KeyEnd,
+	"[1~": emulator.KeyHome, "[4~": emulator.KeyEnd, "[2~": emulator.KeyInsert, "[3~": emulator.KeyDelete,
+	"[5~": emulator.KeyPageUp, "[6~": emulator.KeyPageDown,
+	"OP": emulator.KeyF1, "OQ": emulator.KeyF1 + 1, "OR": emulator.KeyF1 + 2, "OS": emulator.KeyF1 + 3,
+	"[15~": emulator.KeyF1 + 4, "[17~": emulator.KeyF1 + 5, "[18~": emulator.KeyF1 + 6, "[19~": emulator.KeyF1 + 7,
+	"[20~": emulator.KeyF1 + 8, "[21~": emulator.KeyF1 + 9, "[23~": emulator.KeyF1 + 10, "[24~": emulator.KeyF1 + 11,
+}
+
+// A Terminal displays a machine in a terminal and forwards the keys typed to it. Ctrl-C quits.
+type Terminal struct {
+	In  *os.File
+	Out io.Writer
+	// Style and Scale are passed to emulator.RenderScreen.
+	Style string
+	Scale int
+	// FrameRate is the number of times the screen is drawn per second.
+	FrameRate int
+	KeyHold   time.Duration
+}
+
+// Run displays m until ctx is done or Ctrl-C is typed, which calls cancel.
+func (t *Terminal) Run(ctx context.Context, cancel context.CancelFunc, m *Machine) error {
+	restore, err := rawMode(t.In)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	// Use the alternate screen without cursor, and restore the terminal on exit.
+	io.WriteString(t.Out, "\x1b[?1049h\x1b[?25l")
+	defer io.WriteString(t.Out, "\x1b[?25h\x1b[?1049l")
+
+	go t.readKeys(cancel, m)
+	ram := make([]uint16, emulator.RAMSize)
+	ticker := time.NewTicker(time.Second / time.Duration(max(t.FrameRate, 1)))
+	defer ticker.Stop()
+	var frame bytes.Buffer
+	for {
+		m.Screen(ram[emulator.Screen : emulator.Screen+emulator.ScreenSize])
+		frame.Reset()
+		frame.WriteString("\x1b[H")
+		if err := emulator.RenderScreen(&frame, ram, t.Style, t.Scale); err != nil {
+			return err
+		}
+		// Raw mode does not return the carriage on line feeds.
+		if _, err := io.WriteString(t.Out, strings.ReplaceAll(frame.String(), "\n", "\r\n")); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// readKeys forwards the keys typed to m, translating the escape sequences of special keys.
+func (t *Terminal) readKeys(cancel context.CancelFunc, m *Machine) {
+	in := bufio.NewReader(t.In)
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			cancel()
+			return
+		}
+		var key uint16
+		switch {
+		case b == 3:
+			cancel()
+			return
+		case b == '\r' || b == '\n':
+			key = emulator.KeyNewline
+		case b == 127 || b == 8:
+			key = emulator.KeyBackspace
+		case b == 27:
+			key = readEscapeSequence(in)
+		case b >= ' ' && b < 127:
+			key = uint16(b)
+		}
+		if key != 0 {
+			m.Press(key, t.KeyHold)
+		}
+	}
+}
+
+// readEscapeSequence reads the rest of an escape sequence, whose ESC was just read, and returns its key.
+// An ESC not followed by more input is the escape key.
+func readEscapeSequence(in *bufio.Reader) uint16 {
+	if in.Buffered() == 0 {
+		return emulator.KeyEscape
+	}
+	var sequence strings.Builder
+	for in.Buffered() > 0 && sequence.Len() < 8 {
+		b, _ := in.ReadByte()
+		sequence.WriteByte(b)
+		if s := sequence.String(); len(s) > 1 && (b >= 'A' && b <= 'Z' || b == '~') {
+			break
+		}
+	}
+	return escapeSequences[sequence.String()]
+}
+
+// rawMode switches the terminal of in to raw mode without echo, and returns a function restoring its previous mode.
+func rawMode(in *os.File) (func(), error) {
+	stty := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = in
+		return cmd.Output()
+	}
+	state, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() { stty(strings.TrimSpace(string(state))) }, nil
+}
@@ -0,0 +1,44 @@
+package live
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+func TestReadEscapeSequence(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint16
+		// rest is the input left after the sequence.
+		rest string
+	}{
+		{"\x1b", emulator.KeyEscape, ""},
+		{"\x1b[A", emulator.KeyUp, ""},
+		{"\x1bOD", emulator.KeyLeft, ""},
+		{"\x1b[3~", emulator.KeyDelete, ""},
+		{"\x1bOP", emulator.KeyF1, ""},
+		{"\x1b[24~", emulator.KeyF1 + 11, ""},
+		{"\x1b[Cx", emulator.KeyRight, "x"},
+		{"\x1b[6~\x1b[A", emulator.KeyPageDown, "\x1b[A"},
+		{"\x1b[Z", 0, ""},
+		{"\x1b[12345678", 0, "8"},
+	}
+	for _, test := range tests {
+		in := bufio.NewReader(strings.NewReader(test.input))
+		// The ESC is read first, which fills the buffer with the rest of the input.
+		if b, err := in.ReadByte(); err != nil || b != 27 {
+			t.Fatalf("%q: reading ESC returned %q, %v", test.input, b, err)
+		}
+		if got := readEscapeSequence(in); got != test.want {
+			t.Errorf("readEscapeSequence(%q) = %d, want %d", test.input, got, test.want)
+		}
+		var rest strings.Builder
+		in.WriteTo(&rest)
+		if rest.String() != test.rest {
+			t.Errorf("readEscapeSequence(%q) left %q, want %q", test.input, rest.String(), test.rest)
+		}
+	}
+}
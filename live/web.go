@@ -0,0 +1,172 @@
+package live
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+// The page of the web display: a canvas redrawn from /screen as often as the browser allows,
+// and key events posted to /key with their Hack character set code.
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Hack</title>
+<style>
+body { background: #333; display: flex; justify-content: center; align-items: center; height: 100vh; margin: 0; }
+canvas { width: 1024px; height: 512px; image-rendering: pixelated; border: 4px solid #222; }
+</style>
+</head>
+<body>
+<canvas id="screen" width="512" height="256" tabindex="0"></canvas>
+<script>
+const canvas = document.getElementById("screen");
+const context = canvas.getContext("2d");
+const image = context.createImageData(512, 256);
+const keys = {
+  Enter: 128, Backspace: 129, ArrowLeft: 130, ArrowUp: 131, ArrowRight: 132, ArrowDown: 133,
+  Home: 134, End: 135, PageUp: 136, PageDown: 137, Insert: 138, Delete: 139, Escape: 140,
+};
+for (let i = 1; i <= 12; i++) keys["F" + i] = 140 + i;
+
+function code(event) {
+  if (event.key in keys) return keys[event.key];
+  if (event.key.length === 1) return event.key.charCodeAt(0);
+  return 0;
+}
+function post(key) {
+  fetch("/key?code=" + key, { method: "POST" });
+}
+document.addEventListener("keydown", event => {
+  const key = code(event);
+  if (key === 0 || event.ctrlKey || event.metaKey) return;
+  event.preventDefault();
+  if (!event.repeat) post(key);
+});
+document.addEventListener("keyup", event => {
+  if (code(event) !== 0) post(0);
+});
+
+async function draw() {
+  try {
+    const words = new Uint16Array(await (await fetch("/screen")).arrayBuffer());
+    for (let i = 0; i < 512 * 256; i++) {
+      const black = (words[i >> 4] >> (i & 15)) & 1;
+      const value = black ? 0 : 255;
+      image.data[4 * i] = image.data[4 * i + 1] = image.data[4 * i + 2] = value;
+      image.data[4 * i + 3] = 255;
+    }
+    context.putImageData(image, 0, 0);
+    requestAnimationFrame(draw);
+  } catch (error) {
+    document.title = "Hack (stopped)";
+  }
+}
+canvas.focus();
+draw();
+</script>
+</body>
+</html>
+`
+
+// ServeWeb displays m in the web page served on addr, such as localhost:8080, until ctx is done.
+// Since the page controls the keyboard, the host of addr must be a loopback address,
+// and a port alone, such as :8080, listens on 127.0.0.1.
+// ready, if not nil, is called with the address listened on.
+func ServeWeb(ctx context.Context, m *Machine, addr string, ready func(addr string)) error {
+	addr, err := loopbackAddress(addr)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if ready != nil {
+		ready(listener.Addr().String())
+	}
+	server := &http.Server{Handler: newHandler(m), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newHandler serves the page displaying m, its screen and its key presses.
+func newHandler(m *Machine) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	})
+	mux.HandleFunc("/screen", func(w http.ResponseWriter, r *http.Request) {
+		screen := make([]uint16, emulator.ScreenSize)
+		m.Screen(screen)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		binary.Write(w, binary.LittleEndian, screen)
+	})
+	mux.HandleFunc("/key", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin key presses are not allowed", http.StatusForbidden)
+			return
+		}
+		code, err := strconv.ParseUint(r.URL.Query().Get("code"), 10, 16)
+		if err != nil {
+			http.Error(w, "invalid key code", http.StatusBadRequest)
+			return
+		}
+		m.Press(uint16(code), 0)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// loopbackAddress returns addr with the host 127.0.0.1 if it has none, or an error if its host is not a loopback address.
+func loopbackAddress(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("%s is not a loopback address, which only this computer can connect to", host)
+	}
+	return addr, nil
+}
+
+// sameOrigin tells whether r comes from the page served by this server, or from outside a browser, which sends no Origin,
+// so that other web sites open in the browser cannot press keys.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
@@ -0,0 +1,96 @@
+package live
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+func TestScreenHandler(t *testing.T) {
+	computer := emulator.NewComputer(nil)
+	computer.RAM[emulator.Screen] = 0x1234
+	computer.RAM[emulator.Screen+emulator.ScreenSize-1] = 0x8001
+	computer.RAM[emulator.Keyboard] = 0xFFFF
+	handler := newHandler(NewMachine(computer, 0))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/screen", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /screen returned status %d, want %d", response.Code, http.StatusOK)
+	}
+	if got := response.Header().Get("Content-Type"); got != "application/octet-stream" {
+		t.Errorf("GET /screen returned Content-Type %q, want application/octet-stream", got)
+	}
+	body := response.Body.Bytes()
+	if len(body) != 2*emulator.ScreenSize {
+		t.Fatalf("GET /screen returned %d bytes, want %d", len(body), 2*emulator.ScreenSize)
+	}
+	first, last := binary.LittleEndian.Uint16(body), binary.LittleEndian.Uint16(body[len(body)-2:])
+	if first != 0x1234 || last != 0x8001 {
+		t.Errorf("GET /screen returned first word %#x and last word %#x, want 0x1234 and 0x8001", first, last)
+	}
+}
+
+func TestKeyHandler(t *testing.T) {
+	m := NewMachine(emulator.NewComputer(nil), 0)
+	handler := newHandler(m)
+	tests := []struct {
+		name, method, target, origin string
+		wantStatus                   int
+		wantKey                      uint16
+	}{
+		{"press", http.MethodPost, "/key?code=130", "", http.StatusNoContent, emulator.KeyLeft},
+		{"release", http.MethodPost, "/key?code=0", "", http.StatusNoContent, 0},
+		{"from the page", http.MethodPost, "/key?code=65", "http://example.com", http.StatusNoContent, 'A'},
+		{"from another site", http.MethodPost, "/key?code=66", "http://attacker.example", http.StatusForbidden, 'A'},
+		{"from another port", http.MethodPost, "/key?code=66", "http://example.com:8080", http.StatusForbidden, 'A'},
+		{"not a post", http.MethodGet, "/key?code=66", "", http.StatusMethodNotAllowed, 'A'},
+		{"missing code", http.MethodPost, "/key", "", http.StatusBadRequest, 'A'},
+		{"invalid code", http.MethodPost, "/key?code=left", "", http.StatusBadRequest, 'A'},
+		{"code beyond 16 bits", http.MethodPost, "/key?code=65536", "", http.StatusBadRequest, 'A'},
+	}
+	for _, test := range tests {
+		// The requests are sent to example.com.
+		request := httptest.NewRequest(test.method, test.target, nil)
+		if test.origin != "" {
+			request.Header.Set("Origin", test.origin)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != test.wantStatus {
+			t.Errorf("%s: %s %s returned status %d, want %d", test.name, test.method, test.target, response.Code, test.wantStatus)
+		}
+		m.updateKeyboard()
+		if got := m.computer.RAM[emulator.Keyboard]; got != test.wantKey {
+			t.Errorf("%s: keyboard = %d, want %d", test.name, got, test.wantKey)
+		}
+	}
+}
+
+func TestLoopbackAddress(t *testing.T) {
+	tests := []struct {
+		addr, want string
+	}{
+		{":8080", "127.0.0.1:8080"},
+		{"localhost:8080", "localhost:8080"},
+		{"127.0.0.1:0", "127.0.0.1:0"},
+		{"[::1]:8080", "[::1]:8080"},
+		{"0.0.0.0:8080", ""},
+		{"192.168.1.2:8080", ""},
+		{"example.com:8080", ""},
+		{"8080", ""},
+	}
+	for _, test := range tests {
+		got, err := loopbackAddress(test.addr)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("loopbackAddress(%q) = %q, want an error", test.addr, got)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("loopbackAddress(%q) = %q, %v, want %q", test.addr, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Package profile attributes the cycles of Hack programs to their functions, for flat profiles and flame graphs.
+package profile
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/benjaminclauss/nand2tetris/assembler"
+	"github.com/benjaminclauss/nand2tetris/emulator"
+	vm "github.com/benjaminclauss/nand2tetris/virtualmachine"
+)
+
+// TopLevel names the code outside functions, such as the bootstrap code.
+const TopLevel = "(top level)"
+
+// Kinds of instructions that change the call stack.
+const (
+	plain = iota
+	// callJump is the jump of a call to the called function.
+	callJump
+	// returnJump is the jump of a return to the caller.
+	returnJump
+)
+
+// A Profiler counts the cycles spent in each function of a program, and the cycles spent in each call stack.
+type Profiler struct {
+	// functions names the functions, indexed by the values of function.
+	functions []string
+	// function gives the function of each ROM address.
+	function []int
+	kind     []uint8
+
+	// followCalls tells whether calls and returns change the call stack, or else the function executed replaces it.
+	followCalls bool
+
+	stats []Stats
+	// stack holds the nodes of the call stack, from the outermost call.
+	stack []*node
+	root  *node
+	// active counts the frames of each function on the stack, so that recursive calls count once in total cycles,
+	// from the cycle at which the outermost frame was entered.
+	active  []int
+	entered []uint64
+	cycle   uint64
+}
+
+// Stats are the cycles and calls of a function.
+type Stats struct {
+	Function string
+	// Self counts the cycles executing the function itself, and Total those including the functions it calls.
+	Self, Total uint64
+	Calls       uint64
+}
+
+// A node of the tree of call stacks.
+type node struct {
+	function int
+	parent   *node
+	children map[int]*node
+	self     uint64
+}
+
+// NewVMProfiler creates a profiler of a program translated from VM code with the given source map.
+// Cycles are attributed to VM functions, and calls and returns are followed to reconstruct call stacks.
+func NewVMProfiler(sourceMap *vm.SourceMap) *Profiler {
+	p := newProfiler()
+	p.followCalls = true
+	indexes := map[string]int{"": 0}
+	for i, m := range sourceMap.Mappings {
+		f, ok := indexes[m.Function]
+		if !ok {
+			f = len(p.functions)
+			indexes[m.Function] = f
+			p.functions = append(p.functions, m.Function)
+		}
+		end := emulator.ROMSize
+		if i+1 < len(sourceMap.Mappings) {
+			end = sourceMap.Mappings[i+1].ROMAddress
+		}
+		for address := m.ROMAddress; address < end; address++ {
+			p.function[address] = f
+		}
+		// The code of calls and returns ends with the jump to the function or back to the caller.
+		if end > m.ROMAddress && end <= emulator.ROMSize {
+			if strings.HasPrefix(m.Command, "call ") || m.Command == "bootstrap" {
+				p.kind[end-1] = callJump
+			} else if m.Command == "return" {
+				p.kind[end-1] = returnJump
+			}
+		}
+	}
+	p.reset()
+	return p
+}
+
+// NewLabelProfiler creates a profiler of an assembly program, attributing cycles to the closest label before each instruction.
+// Without calls to follow, the calls of a label count the times execution entered its code from elsewhere.
+func NewLabelProfiler(symbols *assembler.SymbolTable) *Profiler {
+	p := newProfiler()
+	type label struct {
+		name    string
+		address int
+	}
+	var labels []label
+	for _, symbol := range symbols.Symbols() {
+		if symbols.IsLabel(symbol) {
+			labels = append(labels, label{symbol, symbols.GetAddress(symbol)})
+		}
+	}
+	slices.SortStableFunc(labels, func(a, b label) int { return cmp.Compare(a.address, b.address) })
+	for i, l := range labels {
+		end := emulator.ROMSize
+		if i+1 < len(labels) {
+			end = labels[i+1].address
+		}
+		// Labels at the same address name the same code, under the last of them.
+		if end == l.address {
+			continue
+		}
+		p.functions = append(p.functions, l.name)
+		for address := l.address; address < end; address++ {
+			p.function[address] = len(p.functions) - 1
+		}
+	}
+	p.reset()
+	return p
+}
+
+func newProfiler() *Profiler {
+	return &Profiler{
+		functions: []string{TopLevel},
+		function:  make([]int, emulator.ROMSize),
+		kind:      make([]uint8, emulator.ROMSize),
+	}
+}
+
+func (p *Profiler) reset() {
+	p.stats = make([]Stats, len(p.functions))
+	p.active = make([]int, len(p.functions))
+	p.entered = make([]uint64, len(p.functions))
+	p.root = &node{function: -1, children: make(map[int]*node)}
+	p.stack = nil
+	p.cycle = 0
+}
+
+// Observe accounts for an executed instruction. It is meant to be called with each record of Computer.RunObserved.
+func (p *Profiler) Observe(record emulator.TraceRecord) error {
+	f := p.function[record.PC]
+	if len(p.stack) == 0 {
+		p.push(f)
+	} else if !p.followCalls && p.stack[0].function != f {
+		p.pop()
+		p.push(f)
+	}
+	p.stack[len(p.stack)-1].self++
+	p.stats[f].Self++
+	p.cycle++
+	switch p.kind[record.PC] {
+	case callJump:
+		// The jump of a call goes to the address in A, which is the function called.
+		p.push(p.function[record.AddressM&(emulator.ROMSize-1)])
+	case returnJump:
+		if len(p.stack) > 1 {
+			p.pop()
+		}
+	}
+	return nil
+}
+
+func (p *Profiler) push(f int) {
+	parent := p.root
+	if len(p.stack) > 0 {
+		parent = p.stack[len(p.stack)-1]
+	}
+	child, ok := parent.children[f]
+	if !ok {
+		child = &node{function: f, parent: parent, children: make(map[int]*node)}
+		parent.children[f] = child
+	}
+	p.stack = append(p.stack, child)
+	p.stats[f].Calls++
+	if p.active[f] == 0 {
+		p.entered[f] = p.cycle
+	}
+	p.active[f]++
+}
+
+func (p *Profiler) pop() {
+	f := p.stack[len(p.stack)-1].function
+	p.stack = p.stack[:len(p.stack)-1]
+	p.active[f]--
+	if p.active[f] == 0 {
+		p.stats[f].Total += p.cycle - p.entered[f]
+	}
+}
+
+// Cycles returns the number of cycles observed.
+func (p *Profiler) Cycles() uint64 {
+	return p.cycle
+}
+
+// Stats returns the statistics of the functions that executed, by decreasing self cycles.
+// Functions still on the call stack count the cycles up to now in their total.
+func (p *Profiler) Stats() []Stats {
+	var stats []Stats
+	for f, s := range p.stats {
+		if s.Calls == 0 {
+			continue
+		}
+		s.Function = p.functions[f]
+		if p.active[f] > 0 {
+			s.Total += p.cycle - p.entered[f]
+		}
+		stats = append(stats, s)
+	}
+	slices.SortStableFunc(stats, func(a, b Stats) int {
+		return cmp.Or(cmp.Compare(b.Self, a.Self), cmp.Compare(a.Function, b.Function))
+	})
+	return stats
+}
+
+// WriteFlat writes the flat profile, one line per function with its self and total cycles and calls.
+func (p *Profiler) WriteFlat(w io.Writer) error {
+	percent := func(cycles uint64) float64 {
+		return 100 * float64(cycles) / float64(max(p.cycle, 1))
+	}
+	if _, err := fmt.Fprintf(w, "%7s %12s %7s %12s %10s  %s\n", "self%", "self", "total%", "total", "calls", "function"); err != nil {
+		return err
+	}
+	for _, s := range p.Stats() {
+		if _, err := fmt.Fprintf(w, "%6.2f%% %12d %6.2f%% %12d %10d  %s\n", percent(s.Self), s.Self, percent(s.Total), s.Total, s.Calls, s.Function); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFolded writes the cycles spent in each call stack in the folded format of flame graph tools,
+// one line per stack as the functions from the outermost separated by semicolons, then the cycles.
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	var lines []string
+	var walk func(n *node, path []string)
+	walk = func(n *node, path []string) {
+		if n.function >= 0 {
+			path = append(path, p.functions[n.function])
+			if n.self > 0 {
+				lines = append(lines, fmt.Sprintf("%s %d", strings.Join(path, ";"), n.self))
+			}
+		}
+		for _, child := range n.children {
+			walk(child, slices.Clip(path))
+		}
+	}
+	walk(p.root, nil)
+	slices.Sort(lines)
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
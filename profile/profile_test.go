@@ -0,0 +1,175 @@
+package profile_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/benjaminclauss/nand2tetris/command"
+	"github.com/benjaminclauss/nand2tetris/emulator"
+	"github.com/benjaminclauss/nand2tetris/profile"
+	vm "github.com/benjaminclauss/nand2tetris/virtualmachine"
+)
+
+// Sys.init calls Main.count 3, which recurses down to 0, then calls Main.leaf twice. Main.unused is never called.
+var sources = []struct{ filename, code string }{
+	{"Sys.vm", `
+function Sys.init 0
+push constant 3
+call Main.count 1
+pop temp 0
+call Main.leaf 0
+pop temp 0
+call Main.leaf 0
+pop temp 0
+label END
+goto END
+`},
+	{"Main.vm", `
+function Main.count 0
+push argument 0
+if-goto RECURSE
+push constant 0
+return
+label RECURSE
+push argument 0
+push constant 1
+sub
+call Main.count 1
+return
+function Main.leaf 0
+push constant 7
+return
+function Main.unused 0
+push constant 0
+return
+`},
+}
+
+// functionRange returns the ROM addresses from the first instruction of function to the next function.
+func functionRange(sourceMap *vm.SourceMap, function string) (start, end int) {
+	start = -1
+	for _, m := range sourceMap.Mappings {
+		if m.Function == function && start < 0 {
+			start = m.ROMAddress
+		} else if m.Function != function && start >= 0 {
+			return start, m.ROMAddress
+		}
+	}
+	return start, emulator.ROMSize
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestVMProfiler(t *testing.T) {
+	var asm bytes.Buffer
+	writer := vm.NewCodeWriter(nopCloser{&asm})
+	if err := writer.WriteInit(256, "Sys.init"); err != nil {
+		t.Fatal(err)
+	}
+	_, bootstrap := writer.Position()
+	for _, source := range sources {
+		instructions, err := vm.Parse(source.filename, strings.NewReader(source.code))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, instruction := range instructions {
+			if err := writer.WriteInstruction(instruction); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	sourceMap := writer.SourceMap()
+	var binary bytes.Buffer
+	if _, err := command.Assemble(&asm, &binary); err != nil {
+		t.Fatal(err)
+	}
+	program, err := emulator.ReadProgram(&binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profiler := profile.NewVMProfiler(sourceMap)
+	// Independently of the profiler, the source map gives the function of every instruction executed.
+	self := map[string]uint64{}
+	observe := func(record emulator.TraceRecord) error {
+		function := profile.TopLevel
+		if m, _ := sourceMap.Lookup(int(record.PC)); m.Function != "" {
+			function = m.Function
+		}
+		self[function]++
+		return profiler.Observe(record)
+	}
+	if halted, err := emulator.NewComputer(program).RunObserved(10_000, observe); err != nil || !halted {
+		t.Fatalf("RunObserved = %v, %v, want the program to halt", halted, err)
+	}
+	cycles := profiler.Cycles()
+
+	// Main.leaf runs its straight code, from its function command to the end of its return, once per call.
+	start, end := functionRange(sourceMap, "Main.leaf")
+	leafSize := uint64(end - start)
+
+	got := map[string]profile.Stats{}
+	for _, s := range profiler.Stats() {
+		got[s.Function] = s
+	}
+	want := map[string]profile.Stats{
+		// The bootstrap runs its code once, to the jump to Sys.init, and stays at the bottom of the stack.
+		profile.TopLevel: {Self: uint64(bootstrap), Total: cycles, Calls: 1},
+		// Sys.init never returns, so its total runs to the end.
+		"Sys.init": {Self: self["Sys.init"], Total: cycles - uint64(bootstrap), Calls: 1},
+		// The recursive calls count once in the total, which is then the self cycles since Main.count calls no other function.
+		"Main.count": {Self: self["Main.count"], Total: self["Main.count"], Calls: 4},
+		"Main.leaf":  {Self: 2 * leafSize, Total: 2 * leafSize, Calls: 2},
+	}
+	if len(got) != len(want) {
+		t.Errorf("the profile has %d functions, want %d: %+v", len(got), len(want), profiler.Stats())
+	}
+	for function, w := range want {
+		w.Function = function
+		if got[function] != w {
+			t.Errorf("%s: got %+v, want %+v", function, got[function], w)
+		}
+	}
+	if self[profile.TopLevel] != uint64(bootstrap) {
+		t.Errorf("the source map attributes %d cycles to the bootstrap, want %d", self[profile.TopLevel], bootstrap)
+	}
+
+	// Each recursive call of Main.count adds a level to the call stack.
+	var folded bytes.Buffer
+	if err := profiler.WriteFolded(&folded); err != nil {
+		t.Fatal(err)
+	}
+	stacks := map[string]bool{}
+	var sum uint64
+	for scanner := bufio.NewScanner(&folded); scanner.Scan(); {
+		// Function names may contain spaces, but not the cycles ending the line.
+		line := scanner.Text()
+		space := strings.LastIndex(line, " ")
+		n, err := strconv.ParseUint(line[space+1:], 10, 64)
+		if space < 0 || err != nil {
+			t.Fatalf("invalid folded line %q", line)
+		}
+		stacks[line[:space]] = true
+		sum += n
+	}
+	count := "(top level);Sys.init;Main.count"
+	for _, stack := range []string{
+		"(top level)", "(top level);Sys.init", "(top level);Sys.init;Main.leaf",
+		count, count + ";Main.count", count + ";Main.count;Main.count", count + ";Main.count;Main.count;Main.count",
+	} {
+		if !stacks[stack] {
+			t.Errorf("the folded profile has no stack %s:\n%s", stack, folded.String())
+		}
+	}
+	if len(stacks) != 7 || sum != cycles {
+		t.Errorf("the folded profile has %d stacks and %d cycles, want 7 and %d:\n%s", len(stacks), sum, cycles, folded.String())
+	}
+}
@@ -0,0 +1,473 @@
+// Package testscript runs the test scripts of the CPU emulator of the course, such as Mult.tst,
+// which load a Hack program, set registers and RAM, run it for a number of cycles and compare outputs with a .cmp file.
+package testscript
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminclauss/nand2tetris/emulator"
+)
+
+// DefaultMaxCycles bounds the cycles run by a repeat without count, which runs until the program halts.
+const DefaultMaxCycles = 100_000_000
+
+// A Runner runs CPU emulator test scripts.
+type Runner struct {
+	// Load creates a computer running the program named by the load command, a .asm or .hack file.
+	Load func(filename string) (*emulator.Computer, error)
+	// Keys, if not nil, are replayed into the keyboard of each program loaded.
+	Keys []emulator.KeyEvent
+	// Echo receives the text of echo commands.
+	Echo      io.Writer
+	MaxCycles uint64
+
+	dir        string
+	computer   *emulator.Computer
+	outputList []column
+	output     io.WriteCloser
+	compare    []string
+	// outputLines counts the lines written to the output, which are compared with the same lines of the compare file.
+	outputLines int
+}
+
+// A command of a script: its words, or the commands of a repeat block.
+type command struct {
+	words []string
+	line  int
+	// count is the number of times a repeat block runs, or -1 to run until the program halts.
+	count int
+	block []command
+}
+
+// A column of the output list, such as RAM[0]%D2.6.2: a value, its format and padding.
+type column struct {
+	name               string
+	format             byte
+	left, width, right int
+}
+
+// NewRunner creates a runner loading programs with load.
+func NewRunner(load func(filename string) (*emulator.Computer, error), echo io.Writer) *Runner {
+	return &Runner{Load: load, Echo: echo, MaxCycles: DefaultMaxCycles}
+}
+
+// RunFile runs a test script, whose file names are relative to its directory.
+func (r *Runner) RunFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r.dir = filepath.Dir(filename)
+	r.computer, r.outputList, r.compare, r.outputLines = nil, nil, nil, 0
+	commands, err := parse(f)
+	if err == nil {
+		err = r.run(commands)
+	}
+	if r.output != nil {
+		if closeErr := r.output.Close(); err == nil {
+			err = closeErr
+		}
+		r.output = nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+func (r *Runner) run(commands []command) error {
+	for _, c := range commands {
+		var err error
+		if c.block != nil || c.count != 0 {
+			err = r.repeat(c)
+		} else {
+			err = r.execute(c.words)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", c.line, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) repeat(c command) error {
+	if c.count >= 0 {
+		for range c.count {
+			if err := r.run(c.block); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if r.computer == nil {
+		return fmt.Errorf("repeat before load")
+	}
+	for start := r.cycle(); !r.computer.Halted() && r.cycle()-start < r.MaxCycles; {
+		if err := r.run(c.block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) cycle() uint64 {
+	if r.computer == nil {
+		return 0
+	}
+	return r.computer.Cycle
+}
+
+func (r *Runner) execute(words []string) error {
+	if len(words) == 0 {
+		return nil
+	}
+	if words[0] != "load" && words[0] != "echo" && words[0] != "clear-echo" &&
+		words[0] != "output-file" && words[0] != "compare-to" && words[0] != "output-list" && r.computer == nil {
+		return fmt.Errorf("%s before load", words[0])
+	}
+	args := words[1:]
+	switch words[0] {
+	case "load":
+		if len(args) != 1 {
+			return fmt.Errorf("load requires a program")
+		}
+		computer, err := r.Load(filepath.Join(r.dir, args[0]))
+		if err != nil {
+			return err
+		}
+		r.computer = computer
+		if r.Keys != nil {
+			r.computer.Input = emulator.NewKeyboardInput(r.Keys)
+		}
+	case "output-file":
+		if len(args) != 1 {
+			return fmt.Errorf("output-file requires a file")
+		}
+		f, err := os.Create(filepath.Join(r.dir, args[0]))
+		if err != nil {
+			return err
+		}
+		r.output = f
+	case "compare-to":
+		if len(args) != 1 {
+			return fmt.Errorf("compare-to requires a file")
+		}
+		text, err := os.ReadFile(filepath.Join(r.dir, args[0]))
+		if err != nil {
+			return err
+		}
+		r.compare = strings.Split(strings.ReplaceAll(string(text), "\r\n", "\n"), "\n")
+	case "output-list":
+		r.outputList = nil
+		for _, arg := range args {
+			c, err := parseColumn(arg)
+			if err != nil {
+				return err
+			}
+			r.outputList = append(r.outputList, c)
+		}
+		return r.writeOutput(r.header())
+	case "output":
+		line, err := r.values()
+		if err != nil {
+			return err
+		}
+		return r.writeOutput(line)
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("set requires a variable and a value")
+		}
+		value, err := strconv.ParseInt(args[1], 0, 32)
+		if err != nil || value < -32768 || value > 65535 {
+			return fmt.Errorf("invalid value %q", args[1])
+		}
+		variable, err := r.variable(args[0])
+		if err != nil {
+			return err
+		}
+		*variable = uint16(value)
+		if args[0] == "PC" {
+			*variable &= emulator.ROMSize - 1
+		}
+	case "tick", "tock", "ticktock":
+		// A clock cycle executes one instruction, which the CPU commits on tock.
+		if words[0] != "tick" {
+			r.computer.Step()
+		}
+	case "screenshot":
+		if len(args) != 1 {
+			return fmt.Errorf("screenshot requires a file")
+		}
+		return r.screenshot(filepath.Join(r.dir, args[0]))
+	case "compare-screen":
+		if len(args) != 1 {
+			return fmt.Errorf("compare-screen requires a file")
+		}
+		return r.compareScreen(filepath.Join(r.dir, args[0]))
+	case "echo":
+		fmt.Fprintln(r.Echo, strings.Join(args, " "))
+	case "clear-echo":
+	default:
+		return fmt.Errorf("unsupported command %q", words[0])
+	}
+	return nil
+}
+
+// variable returns the register or RAM word named by a script, such as RAM[16], A, D, PC or KBD.
+func (r *Runner) variable(name string) (*uint16, error) {
+	c := r.computer
+	switch name {
+	case "A":
+		return &c.A, nil
+	case "D":
+		return &c.D, nil
+	case "PC":
+		return &c.PC, nil
+	case "KBD":
+		return &c.RAM[emulator.Keyboard], nil
+	}
+	if index, ok := strings.CutPrefix(name, "RAM["); ok && strings.HasSuffix(index, "]") {
+		address, err := strconv.ParseUint(strings.TrimSuffix(index, "]"), 10, 16)
+		if err == nil && address < emulator.RAMSize {
+			return &c.RAM[address], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown variable %q", name)
+}
+
+// screenshot writes the screen as a PNG image.
+func (r *Runner) screenshot(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := emulator.WriteScreenPNG(f, r.computer.RAM); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// compareScreen compares the screen with a PNG image written by screenshot, failing if any pixel differs.
+func (r *Runner) compareScreen(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	want, err := emulator.ReadScreenPNG(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(filename), err)
+	}
+	if diff := emulator.ScreenDiff(r.computer.RAM, want); diff > 0 {
+		return fmt.Errorf("screen differs from %s in %d pixels", filepath.Base(filename), diff)
+	}
+	return nil
+}
+
+var columnPattern = regexp.MustCompile(`^(.+)%([DXBS])(\d+)\.(\d+)\.(\d+)$`)
+
+func parseColumn(spec string) (column, error) {
+	match := columnPattern.FindStringSubmatch(spec)
+	if match == nil {
+		return column{}, fmt.Errorf("invalid output column %q", spec)
+	}
+	left, _ := strconv.Atoi(match[3])
+	width, _ := strconv.Atoi(match[4])
+	right, _ := strconv.Atoi(match[5])
+	return column{match[1], match[2][0], left, width, right}, nil
+}
+
+// header returns the line naming the columns, each name centered in its column and truncated to fit.
+func (r *Runner) header() string {
+	line := "|"
+	for _, c := range r.outputList {
+		total := c.left + c.width + c.right
+		name := c.name[:min(len(c.name), total)]
+		left := (total - len(name)) / 2
+		line += strings.Repeat(" ", left) + name + strings.Repeat(" ", total-left-len(name)) + "|"
+	}
+	return line
+}
+
+// values returns the line of the current values of the columns.
+func (r *Runner) values() (string, error) {
+	line := "|"
+	for _, c := range r.outputList {
+		variable, err := r.variable(c.name)
+		if err != nil {
+			return "", err
+		}
+		var text string
+		switch c.format {
+		case 'D':
+			text = fmt.Sprintf("%*d", c.width, int16(*variable))
+		case 'X':
+			text = fmt.Sprintf("%0*X", c.width, *variable)
+		case 'B':
+			text = fmt.Sprintf("%0*b", c.width, *variable)
+		case 'S':
+			text = fmt.Sprintf("%*s", c.width, strconv.Itoa(int(*variable)))
+		}
+		if len(text) > c.width {
+			text = text[len(text)-c.width:]
+		}
+		line += strings.Repeat(" ", c.left) + text + strings.Repeat(" ", c.right) + "|"
+	}
+	return line, nil
+}
+
+// writeOutput writes a line to the output file and compares it with the same line of the compare file.
+func (r *Runner) writeOutput(line string) error {
+	r.outputLines++
+	if r.output != nil {
+		if _, err := io.WriteString(r.output, line+"\n"); err != nil {
+			return err
+		}
+	}
+	if r.compare == nil {
+		return nil
+	}
+	if r.outputLines > len(r.compare) || r.compare[r.outputLines-1] != line {
+		want := ""
+		if r.outputLines <= len(r.compare) {
+			want = r.compare[r.outputLines-1]
+		}
+		return fmt.Errorf("comparison failure at line %d: got %s, want %s", r.outputLines, line, want)
+	}
+	return nil
+}
+
+// parse reads the commands of a script. Commands are words separated by white space and ended by a comma,
+// a semicolon or an exclamation mark, and repeat [N] { ... } blocks hold commands. Comments are as in Java.
+func parse(script io.Reader) ([]command, error) {
+	text, err := io.ReadAll(bufio.NewReader(script))
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := tokenize(string(text))
+	if err != nil {
+		return nil, err
+	}
+	commands, rest, err := parseBlock(tokens, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("line %d: unexpected %q", rest[0].line, rest[0].text)
+	}
+	return commands, nil
+}
+
+type token struct {
+	text string
+	line int
+}
+
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(text[i:], "//"):
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(text[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"':
+			end := strings.IndexByte(text[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			tokens = append(tokens, token{text[i+1 : i+1+end], line})
+			i += end + 2
+		case strings.IndexByte(",;!{}", c) >= 0:
+			tokens = append(tokens, token{string(c), line})
+			i++
+		default:
+			start := i
+			for i < len(text) && strings.IndexByte(" \t\r\n,;!{}\"", text[i]) < 0 && !strings.HasPrefix(text[i:], "//") {
+				i++
+			}
+			tokens = append(tokens, token{text[start:i], line})
+		}
+	}
+	return tokens, nil
+}
+
+// parseBlock parses commands up to the end of tokens or, within a block, up to its closing brace.
+func parseBlock(tokens []token, inBlock bool) ([]command, []token, error) {
+	var commands []command
+	current := command{}
+	for len(tokens) > 0 {
+		t := tokens[0]
+		tokens = tokens[1:]
+		switch t.text {
+		case ",", ";", "!":
+			if len(current.words) > 0 {
+				commands = append(commands, current)
+			}
+			current = command{}
+		case "}":
+			if !inBlock {
+				return nil, nil, fmt.Errorf("line %d: unexpected }", t.line)
+			}
+			if len(current.words) > 0 {
+				commands = append(commands, current)
+			}
+			return commands, tokens, nil
+		case "{":
+			if len(current.words) == 0 || current.words[0] != "repeat" || len(current.words) > 2 {
+				return nil, nil, fmt.Errorf("line %d: unexpected {", t.line)
+			}
+			repeat := command{line: current.line, count: -1}
+			if len(current.words) == 2 {
+				n, err := strconv.Atoi(current.words[1])
+				if err != nil || n < 0 {
+					return nil, nil, fmt.Errorf("line %d: invalid repeat count %q", t.line, current.words[1])
+				}
+				repeat.count = n
+			}
+			var err error
+			if repeat.block, tokens, err = parseBlock(tokens, true); err != nil {
+				return nil, nil, err
+			}
+			if repeat.block == nil {
+				repeat.block = []command{}
+			}
+			commands = append(commands, repeat)
+			current = command{}
+		default:
+			if len(current.words) == 0 {
+				current.line = t.line
+			}
+			current.words = append(current.words, t.text)
+		}
+	}
+	if inBlock {
+		return nil, nil, fmt.Errorf("missing }")
+	}
+	if len(current.words) > 0 {
+		commands = append(commands, current)
+	}
+	return commands, tokens, nil
+}
@@ -0,0 +1,70 @@
+package virtualmachine
+
+import "slices"
+
+// A CallGraph records the functions of a VM program and the functions each of them calls.
+// Calls made outside of any function are attributed to the unnamed function "".
+type CallGraph struct {
+	// Functions lists the defined functions in the order they were added.
+	Functions []string
+	defined   map[string]bool
+	calls     map[string][]string
+}
+
+// NewCallGraph creates an empty call graph.
+func NewCallGraph() *CallGraph {
+	return &CallGraph{defined: make(map[string]bool), calls: make(map[string][]string)}
+}
+
+// AddFile adds the function and call commands of a .vm file to the graph.
+// Nothing is added if the file has malformed commands, which are returned as ParseErrors.
+func (g *CallGraph) AddFile(filename string) error {
+	instructions, err := ParseFile(filename)
+	if err != nil {
+		return err
+	}
+	current := ""
+	for _, instruction := range instructions {
+		switch instruction.Op {
+		case OpFunction:
+			current = instruction.Label
+			if !g.defined[current] {
+				g.defined[current] = true
+				g.Functions = append(g.Functions, current)
+			}
+		case OpCall:
+			if callee := instruction.Label; !slices.Contains(g.calls[current], callee) {
+				g.calls[current] = append(g.calls[current], callee)
+			}
+		}
+	}
+	return nil
+}
+
+// Defines returns true if the function is defined by one of the added files.
+func (g *CallGraph) Defines(function string) bool {
+	return g.defined[function]
+}
+
+// Calls returns the functions called by function, in order of first call.
+func (g *CallGraph) Calls(function string) []string {
+	return g.calls[function]
+}
+
+// Reachable returns the set of functions that can be called, directly or indirectly, from the roots.
+// The roots themselves are included, and so are the calls made outside of any function.
+func (g *CallGraph) Reachable(roots ...string) map[string]bool {
+	reachable := make(map[string]bool)
+	pending := append([]string{""}, roots...)
+	for len(pending) > 0 {
+		function := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[function] {
+			continue
+		}
+		reachable[function] = true
+		pending = append(pending, g.calls[function]...)
+	}
+	delete(reachable, "")
+	return reachable
+}
@@ -0,0 +1,93 @@
+package virtualmachine
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeProgram writes VM files named after the keys of files into a temporary directory, returning their paths in order.
+func writeProgram(t *testing.T, files map[string]string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var filenames []string
+	for name, source := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+	}
+	slices.Sort(filenames)
+	return filenames
+}
+
+func TestCallGraphReachable(t *testing.T) {
+	files := writeProgram(t, map[string]string{
+		"Sys.vm": `
+function Sys.init 0
+call Main.main 0
+label HALT
+goto HALT`,
+		"Main.vm": `
+function Main.main 0
+call Math.double 1
+return
+function Main.unused 0
+call Math.half 1
+return`,
+		"Math.vm": `
+function Math.double 0
+call Math.add 2
+return
+function Math.add 0
+push argument 0
+return
+function Math.half 0
+call Math.half 1
+return`,
+	})
+	graph := NewCallGraph()
+	for _, file := range files {
+		if err := graph.AddFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !graph.Defines("Math.add") || graph.Defines("Math.sub") {
+		t.Errorf("Defines(Math.add) = %v, Defines(Math.sub) = %v, want true and false", graph.Defines("Math.add"), graph.Defines("Math.sub"))
+	}
+	if calls := graph.Calls("Math.double"); !slices.Equal(calls, []string{"Math.add"}) {
+		t.Errorf("Calls(Math.double) = %q, want [Math.add]", calls)
+	}
+
+	reachable := graph.Reachable("Sys.init")
+	for _, function := range []string{"Sys.init", "Main.main", "Math.double", "Math.add"} {
+		if !reachable[function] {
+			t.Errorf("%s is not reachable from Sys.init through a chain of calls", function)
+		}
+	}
+	for _, function := range []string{"Main.unused", "Math.half"} {
+		if reachable[function] {
+			t.Errorf("%s is reachable from Sys.init, although only unreachable functions call it", function)
+		}
+	}
+	if reachable := graph.Reachable("Sys.missing"); len(reachable) != 1 || !reachable["Sys.missing"] {
+		t.Errorf("Reachable from an undefined function = %v, want only itself", reachable)
+	}
+}
+
+func TestCallGraphMalformedCommands(t *testing.T) {
+	for _, source := range []string{
+		"function Sys.init 0\ncall\nreturn\n",
+		"function Sys.init 0\ncall Main.main\nreturn\n",
+		"function\n",
+		"function Sys.init -1\nreturn\n",
+	} {
+		graph := NewCallGraph()
+		file := writeProgram(t, map[string]string{"Sys.vm": source})[0]
+		if err := graph.AddFile(file); err == nil {
+			t.Errorf("AddFile of %q succeeded", source)
+		}
+	}
+}
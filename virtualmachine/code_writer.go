@@ -11,21 +11,130 @@ import (
 // A CodeWriter translates VM commands into Hack assembly code.
 // The VM represents true. and false. as -1 (minus one, 0xFFFF) and 0 (zero, 0x0000), respectively.
 type CodeWriter struct {
-	output              io.WriteCloser
+	output              *countingWriter
+	annotate            bool
+	sourceMap           SourceMap
 	boolean             int
 	returnLabelCount    int
 	currentFunctionName string
 	currentFilename     string
+	staticPrefix        string
 }
 
 // NewCodeWriter opens the output stream and gets ready to write to it.
 func NewCodeWriter(output io.WriteCloser) *CodeWriter {
-	return &CodeWriter{output: output, returnLabelCount: 1}
+	return &CodeWriter{output: &countingWriter{WriteCloser: output}, returnLabelCount: 1}
+}
+
+// SetAnnotate controls whether each command's block is preceded by a comment naming the VM source, e.g. "// Main.vm:12 push local 3".
+func (cw *CodeWriter) SetAnnotate(annotate bool) {
+	cw.annotate = annotate
+}
+
+// BeginCommand informs the CodeWriter that the translation of the VM command at the given line of the current file is started.
+// It records a source mapping for the command and writes its annotation if enabled.
+func (cw *CodeWriter) BeginCommand(line int, command string) error {
+	function := cw.currentFunctionName
+	// A function command belongs to the function it declares.
+	if fields := strings.Fields(command); len(fields) > 1 && fields[0] == "function" {
+		function = fields[1]
+	}
+	mapping := SourceMapping{
+		AsmLine:    cw.output.lines + 1,
+		ROMAddress: cw.output.romAddress,
+		Line:       line,
+		Function:   function,
+		Command:    command,
+	}
+	if cw.currentFilename != "" {
+		mapping.File = filepath.Base(cw.currentFilename)
+	}
+	cw.sourceMap.Mappings = append(cw.sourceMap.Mappings, mapping)
+	if !cw.annotate {
+		return nil
+	}
+	if mapping.File == "" {
+		return cw.WriteLine("// " + command + "\n")
+	}
+	return cw.WriteLine("// " + mapping.File + ":" + strconv.Itoa(line) + " " + command + "\n")
+}
+
+// WriteInstruction writes the assembly code that is the translation of the given instruction, recording its source position.
+// If the instruction comes from another file than the current one, SetFilename is called first.
+func (cw *CodeWriter) WriteInstruction(instruction Instruction) error {
+	if instruction.Pos.File != cw.currentFilename {
+		cw.SetFilename(instruction.Pos.File)
+	}
+	if err := cw.BeginCommand(instruction.Pos.Line, instruction.String()); err != nil {
+		return err
+	}
+	switch instruction.Op {
+	case OpAdd, OpSub, OpNeg, OpEq, OpGt, OpLt, OpAnd, OpOr, OpNot:
+		cw.WriteArithmetic(instruction.Op.String())
+	case OpPush, OpPop:
+		cw.WritePushPop(instruction.Op, instruction.Segment.String(), instruction.Index)
+	case OpLabel:
+		return cw.WriteLabel(instruction.Label)
+	case OpGoto:
+		return cw.WriteGoto(instruction.Label)
+	case OpIfGoto:
+		return cw.WriteIf(instruction.Label)
+	case OpFunction:
+		return cw.WriteFunction(instruction.Label, instruction.Index)
+	case OpCall:
+		return cw.WriteCall(instruction.Label, instruction.Index)
+	case OpReturn:
+		return cw.WriteReturn()
+	}
+	return nil
+}
+
+// SourceMap returns the source mappings of the commands begun so far.
+func (cw *CodeWriter) SourceMap() *SourceMap {
+	return &cw.sourceMap
 }
 
 // SetFilename informs the CodeWriter that the translation of a new VM file is started.
+// Static variables of the file are named after its base name without the .vm extension,
+// and so are the labels generated for its comparisons, whose numbering restarts with each file.
+// This keeps files translated by separate CodeWriters from clashing when their output is concatenated.
 func (cw *CodeWriter) SetFilename(filename string) {
 	cw.currentFilename = filename
+	cw.staticPrefix = FileStem(filename)
+	cw.currentFunctionName = ""
+	cw.boolean = 0
+	cw.returnLabelCount = 1
+}
+
+// Position returns the number of lines and instructions written so far.
+func (cw *CodeWriter) Position() (lines, romAddress int) {
+	return cw.output.lines, cw.output.romAddress
+}
+
+// scopedLabel qualifies a label with the function being translated, as in "Main.loop$END".
+// Outside any function, labels are qualified with the current file instead, and left bare if there is none.
+func (cw *CodeWriter) scopedLabel(label string) string {
+	scope := cw.currentFunctionName
+	if scope == "" {
+		scope = cw.staticPrefix
+	}
+	if scope == "" {
+		return label
+	}
+	return scope + "$" + label
+}
+
+// labelPrefix qualifies generated labels with the current file, if any.
+func (cw *CodeWriter) labelPrefix() string {
+	if cw.staticPrefix == "" {
+		return ""
+	}
+	return cw.staticPrefix + "."
+}
+
+// FileStem returns the base name of filename with a trailing .vm extension removed, e.g. "dir/Main.vm" yields "Main".
+func FileStem(filename string) string {
+	return strings.TrimSuffix(filepath.Base(filename), ".vm")
 }
 
 // WriteArithmetic writes the assembly code that is the translation of the given arithmetic command.
@@ -61,19 +170,20 @@ func (cw *CodeWriter) writeUnaryOperation(command string) {
 // TODO: Clean.
 func (cw *CodeWriter) writeComparison(command string) {
 	cw.boolean += 1
-	comp := strings.ToUpper(command)
+	jump := strings.ToUpper(command)
+	comp := cw.labelPrefix() + jump
 	count := strconv.Itoa(cw.boolean)
 	io.WriteString(cw.output, "@SP\nAM=M-1\nD=M\nA=A-1\nD=M-D\n"+
-		"@"+comp+".true."+count+"\nD;J"+comp+"\n"+
+		"@"+comp+".true."+count+"\nD;J"+jump+"\n"+
 		"@SP\nA=M-1\nM=0\n@"+comp+".after."+count+"\n"+
 		"0;JMP\n("+comp+".true."+count+")\n@SP\nA=M-1\n"+
 		"M=-1\n("+comp+".after."+count+")\n")
 }
 
-// WritePushPop writes the assembly code that is the translation of the given command where command is either CPush or CPop.
-func (cw *CodeWriter) WritePushPop(command CommandType, segment string, index int) {
+// WritePushPop writes the assembly code that is the translation of the given command where op is either OpPush or OpPop.
+func (cw *CodeWriter) WritePushPop(op Op, segment string, index int) {
 	// TODO: Dry.
-	if command == CPush {
+	if op == OpPush {
 		if segment == "constant" {
 			// Store constant in register.
 			io.WriteString(cw.output, "@"+strconv.Itoa(index)+"\n")
@@ -113,8 +223,7 @@ func (cw *CodeWriter) WritePushPop(command CommandType, segment string, index in
 			// Get address of segment.
 			// Each static variable j in a VM file Xxx. vm is translated into the assembly symbol Xxx.j.
 			// In the subsequent assembly process, these symbolic variables will be allocated RAM space by the Hack assembler.
-			filename := strings.TrimRight(filepath.Base(cw.currentFilename), ".vm")
-			io.WriteString(cw.output, "@"+filename+"."+strconv.Itoa(index)+"\n")
+			io.WriteString(cw.output, "@"+cw.staticPrefix+"."+strconv.Itoa(index)+"\n")
 			io.WriteString(cw.output, "D=M\n")
 			// Push value to stack.
 			io.WriteString(cw.output, "@SP\n")
@@ -125,7 +234,7 @@ func (cw *CodeWriter) WritePushPop(command CommandType, segment string, index in
 			io.WriteString(cw.output, "M=M+1\n")
 		}
 	}
-	if command == CPop {
+	if op == OpPop {
 		name := map[string]string{"local": "LCL", "argument": "ARG", "this": "THIS", "that": "THAT", "temp": "5", "pointer": "3"}[segment]
 		if slices.Contains([]string{"local", "argument", "this", "that", "temp", "pointer"}, segment) {
 			io.WriteString(cw.output, "@"+name+"\n")
@@ -158,8 +267,7 @@ func (cw *CodeWriter) WritePushPop(command CommandType, segment string, index in
 			// Point at segment.
 			// Each static variable j in a VM file Xxx. vm is translated into the assembly symbol Xxx.j.
 			// In the subsequent assembly process, these symbolic variables will be allocated RAM space by the Hack assembler.
-			filename := strings.TrimRight(filepath.Base(cw.currentFilename), ".vm")
-			io.WriteString(cw.output, "@"+filename+"."+strconv.Itoa(index)+"\n")
+			io.WriteString(cw.output, "@"+cw.staticPrefix+"."+strconv.Itoa(index)+"\n")
 			// Save value.
 			io.WriteString(cw.output, "M=D\n")
 		}
@@ -170,13 +278,24 @@ func (cw *CodeWriter) WritePushPop(command CommandType, segment string, index in
 // (LCL, ARG, THIS, and THAT, respectively). Thus any access to the ith entry of any one of these segments should be translated to assembly code that accesses address (base + i)
 // in the RAM, where base is the current value stored in the register dedicated to the respective segment.
 
+// DefaultStackBase and DefaultEntryFunction are the bootstrap settings of the standard VM mapping on the Hack platform.
+const (
+	DefaultStackBase     = 256
+	DefaultEntryFunction = "Sys.init"
+)
+
 // WriteInit writes assembly code that effects the VM initialization, also called bootstrap code.
+// It sets SP to stackBase and calls entryFunction, normally DefaultStackBase and DefaultEntryFunction.
 // This code must be placed at the beginning of the output file.
-func (cw *CodeWriter) WriteInit() error {
-	cw.WriteLine("@256\nD=A\n@SP\nM=D\n")
-	cw.WriteCall("Sys.init", 0)
-	// Do I need to 0;JMP after?
-	return nil
+func (cw *CodeWriter) WriteInit(stackBase int, entryFunction string) error {
+	if err := cw.BeginCommand(0, "bootstrap"); err != nil {
+		return err
+	}
+	if err := cw.WriteLine("@" + strconv.Itoa(stackBase) + "\nD=A\n@SP\nM=D\n"); err != nil {
+		return err
+	}
+	// The entry function never returns, so nothing follows the call.
+	return cw.WriteCall(entryFunction, 0)
 }
 
 func (cw *CodeWriter) WriteLine(s string) error {
@@ -186,12 +305,12 @@ func (cw *CodeWriter) WriteLine(s string) error {
 
 // WriteLabel writes assembly code that effects the `label` command.
 func (cw *CodeWriter) WriteLabel(label string) error {
-	return cw.WriteLine("(" + cw.currentFunctionName + "$" + label + ")\n")
+	return cw.WriteLine("(" + cw.scopedLabel(label) + ")\n")
 }
 
 // WriteGoto writes assembly code that effects the `goto` command.
 func (cw *CodeWriter) WriteGoto(label string) error {
-	return cw.WriteLine("@" + cw.currentFunctionName + "$" + label + "\n0;JMP\n")
+	return cw.WriteLine("@" + cw.scopedLabel(label) + "\n0;JMP\n")
 }
 
 // WriteIf writes assembly code that effects the if-goto command.
@@ -209,31 +328,30 @@ func (cw *CodeWriter) WriteIf(label string) error {
 	// io.WriteString(cw.output, "@"+label+"\n")
 	// io.WriteString(cw.output, "D;JGT"+"\n")
 	// return "@" + cw.funcName + "$" + label + "\n0;JMP\n"
-	return cw.WriteLine("@" + cw.currentFunctionName + "$" + label + "\nD;JNE\n")
+	return cw.WriteLine("@" + cw.scopedLabel(label) + "\nD;JNE\n")
 }
 
 // WriteCall writes assembly code that effects the call command.
 //
-// This command effects an unconditional goto operation, causing execution to continue from the location marked by the label.
-// The jump destination must be located in the same function.
+// The return address is labeled CallerName$ret.i, where i counts the calls made by the calling function,
+// so that labels stay unique even when separately translated programs are combined.
 func (cw *CodeWriter) WriteCall(functionName string, numArgs int) error {
-	count := strconv.Itoa(cw.returnLabelCount)
+	returnLabel := cw.scopedLabel("ret." + strconv.Itoa(cw.returnLabelCount))
 	cw.returnLabelCount += 1
-	io.WriteString(cw.output, "@SP\nD=M\n@R13\nM=D\n")
-	io.WriteString(cw.output, "@ret."+count+"\nD=A\n@SP\nA=M\nM=D\n")
-	io.WriteString(cw.output, "@SP\nM=M+1\n")
-	io.WriteString(cw.output, "@"+"LCL"+"\nD=M\n@SP\nA=M\nM=D\n")
-	io.WriteString(cw.output, "@SP\nM=M+1\n")
-	io.WriteString(cw.output, "@"+"ARG"+"\nD=M\n@SP\nA=M\nM=D\n")
-	io.WriteString(cw.output, "@SP\nM=M+1\n")
-	io.WriteString(cw.output, "@"+"THIS"+"\nD=M\n@SP\nA=M\nM=D\n")
-	io.WriteString(cw.output, "@SP\nM=M+1\n")
-	io.WriteString(cw.output, "@"+"THAT"+"\nD=M\n@SP\nA=M\nM=D\n")
-	io.WriteString(cw.output, "@SP\nM=M+1\n")
-	io.WriteString(cw.output, "@R13\nD=M\n@"+strconv.Itoa(numArgs)+"\nD=D-A\n@ARG\nM=D\n"+
-		"@SP\nD=M\n@LCL\nM=D\n@"+functionName+"\n"+
-		"0;JMP\n(ret."+count+")\n")
-	return nil
+	return cw.WriteLine("@SP\nD=M\n@R13\nM=D\n" +
+		"@" + returnLabel + "\nD=A\n@SP\nA=M\nM=D\n" +
+		"@SP\nM=M+1\n" +
+		"@" + "LCL" + "\nD=M\n@SP\nA=M\nM=D\n" +
+		"@SP\nM=M+1\n" +
+		"@" + "ARG" + "\nD=M\n@SP\nA=M\nM=D\n" +
+		"@SP\nM=M+1\n" +
+		"@" + "THIS" + "\nD=M\n@SP\nA=M\nM=D\n" +
+		"@SP\nM=M+1\n" +
+		"@" + "THAT" + "\nD=M\n@SP\nA=M\nM=D\n" +
+		"@SP\nM=M+1\n" +
+		"@R13\nD=M\n@" + strconv.Itoa(numArgs) + "\nD=D-A\n@ARG\nM=D\n" +
+		"@SP\nD=M\n@LCL\nM=D\n@" + functionName + "\n" +
+		"0;JMP\n(" + returnLabel + ")\n")
 }
 
 // WriteReturn writes assembly code that effects the return command.
@@ -329,6 +447,7 @@ func (cw *CodeWriter) WriteReturn() error {
 // WriteFunction writes assembly code that effects the function command.
 func (cw *CodeWriter) WriteFunction(functionName string, numLocals int) error {
 	cw.currentFunctionName = functionName
+	cw.returnLabelCount = 1
 	s := "(" + functionName + ")\n@SP\nA=M\n"
 	for i := 0; i < numLocals; i++ {
 		s += "M=0\nA=A+1\n"
@@ -346,3 +465,28 @@ func (cw *CodeWriter) WriteFunction(functionName string, numLocals int) error {
 func (cw *CodeWriter) Close() error {
 	return cw.output.Close()
 }
+
+// countingWriter tracks the number of lines and instructions written through it,
+// so that positions in the generated assembly can be recorded in the source map.
+type countingWriter struct {
+	io.WriteCloser
+	lines      int
+	romAddress int
+	line       []byte
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\n' {
+			w.line = append(w.line, b)
+			continue
+		}
+		w.lines++
+		// Labels, comments and blank lines do not occupy ROM.
+		if text := strings.TrimSpace(string(w.line)); text != "" && !strings.HasPrefix(text, "(") && !strings.HasPrefix(text, "//") {
+			w.romAddress++
+		}
+		w.line = w.line[:0]
+	}
+	return w.WriteCloser.Write(p)
+}
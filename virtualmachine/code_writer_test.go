@@ -0,0 +1,160 @@
+package virtualmachine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFileStem(t *testing.T) {
+	tests := []struct {
+		filename, want string
+	}{
+		{"Item.vm", "Item"},
+		{"dir/Item.vm", "Item"},
+		{"Mvm.vm", "Mvm"},
+		{"MySys.vm", "MySys"},
+		{"a.b.vm", "a.b"},
+		{"dir.vm/Main.vm", "Main"},
+		{"Main", "Main"},
+		{"Main.vmx", "Main.vmx"},
+		{"vm", "vm"},
+	}
+	for _, test := range tests {
+		if got := FileStem(test.filename); got != test.want {
+			t.Errorf("FileStem(%q) = %q, want %q", test.filename, got, test.want)
+		}
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+func (failingWriter) Close() error              { return nil }
+
+func TestWriteInitReportsWriteErrors(t *testing.T) {
+	for _, annotate := range []bool{false, true} {
+		cw := NewCodeWriter(failingWriter{})
+		cw.SetAnnotate(annotate)
+		if err := cw.WriteInit(DefaultStackBase, DefaultEntryFunction); err == nil {
+			t.Errorf("WriteInit with annotate %v to a failing writer succeeded", annotate)
+		}
+	}
+}
+
+// returnLabels translates the VM programs of files, each by its own CodeWriter if separate, and returns the return
+// address labels declared in the concatenated assembly.
+func returnLabels(t *testing.T, separate bool, files map[string]string) []string {
+	t.Helper()
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var asm bytes.Buffer
+	cw := NewCodeWriter(nopCloser{&asm})
+	for _, name := range names {
+		if separate {
+			cw = NewCodeWriter(nopCloser{&asm})
+		}
+		instructions, err := Parse(name, strings.NewReader(files[name]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, instruction := range instructions {
+			if err := cw.WriteInstruction(instruction); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return declaredReturnLabels(asm.String())
+}
+
+// declaredReturnLabels returns the return address labels declared in asm, in order.
+func declaredReturnLabels(asm string) []string {
+	var labels []string
+	for _, match := range returnLabelPattern.FindAllStringSubmatch(asm, -1) {
+		labels = append(labels, match[1])
+	}
+	return labels
+}
+
+var returnLabelPattern = regexp.MustCompile(`(?m)^\((.*\$ret\.\d+)\)$`)
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestReturnLabels(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name: "two calls in the same function",
+			files: map[string]string{"Main.vm": `
+function Main.main 0
+call Math.f 0
+call Math.f 0
+return`},
+			want: []string{"Main.main$ret.1", "Main.main$ret.2"},
+		},
+		{
+			name: "the same call in two functions",
+			files: map[string]string{"Main.vm": `
+function Main.f 0
+call Math.f 0
+return
+function Main.g 0
+call Math.f 0
+return`},
+			want: []string{"Main.f$ret.1", "Main.g$ret.1"},
+		},
+		{
+			name: "the same function name in two files",
+			files: map[string]string{
+				"A.vm": "call Math.f 0\nfunction A.main 0\ncall Math.f 0\nreturn\n",
+				"B.vm": "call Math.f 0\nfunction B.main 0\ncall Math.f 0\nreturn\n",
+			},
+			want: []string{"A$ret.1", "A.main$ret.1", "B$ret.1", "B.main$ret.1"},
+		},
+	}
+	for _, test := range tests {
+		for _, separate := range []bool{false, true} {
+			labels := returnLabels(t, separate, test.files)
+			if !slices.Equal(labels, test.want) {
+				t.Errorf("%s, separate writers %v: return labels %q, want %q", test.name, separate, labels, test.want)
+			}
+		}
+	}
+}
+
+func TestSetFilenameResetsReturnLabels(t *testing.T) {
+	var asm bytes.Buffer
+	cw := NewCodeWriter(nopCloser{&asm})
+	cw.SetFilename("A.vm")
+	for range 2 {
+		if err := cw.WriteCall("Math.f", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cw.SetFilename("B.vm")
+	if cw.returnLabelCount != 1 {
+		t.Errorf("SetFilename left returnLabelCount at %d, want 1", cw.returnLabelCount)
+	}
+	if err := cw.WriteCall("Math.f", 0); err != nil {
+		t.Fatal(err)
+	}
+	labels := declaredReturnLabels(asm.String())
+	if want := []string{"A$ret.1", "A$ret.2", "B$ret.1"}; !slices.Equal(labels, want) {
+		t.Errorf("return labels %q, want %q", labels, want)
+	}
+}
@@ -0,0 +1,73 @@
+package virtualmachine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// FormatIndent is the indentation of the commands in a function body.
+const FormatIndent = "    "
+
+// Format returns the canonical formatting of the VM code of the named file read from input.
+//
+// Commands are written in canonical syntax, one per line, and the bodies of functions are indented under their declarations.
+// Comments are preserved with the indentation of the following command, runs of blank lines are collapsed,
+// and every function declaration but the first line of the file is preceded by a blank line.
+func Format(filename string, input io.Reader) ([]byte, error) {
+	var out bytes.Buffer
+	var errs ParseErrors
+	indent := ""
+	pendingBlank := false
+	writeLines := func(lines []string, indent string, forceBlank bool) {
+		for _, line := range lines {
+			if line == "" {
+				pendingBlank = true
+				continue
+			}
+			if (pendingBlank || forceBlank) && out.Len() > 0 {
+				out.WriteString("\n")
+			}
+			pendingBlank, forceBlank = false, false
+			out.WriteString(indent + line + "\n")
+		}
+		if forceBlank {
+			pendingBlank = true
+		}
+	}
+
+	commands, trailing, err := readCommands(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, command := range commands {
+		instruction, err := ParseInstruction(command.text, Position{File: filename, Line: command.line})
+		if err != nil {
+			var parseError *ParseError
+			if errors.As(err, &parseError) {
+				errs = append(errs, parseError)
+			}
+			continue
+		}
+		commandIndent := indent
+		if instruction.Op == OpFunction {
+			commandIndent, indent = "", FormatIndent
+		}
+		writeLines(command.leading, commandIndent, instruction.Op == OpFunction)
+		if pendingBlank && out.Len() > 0 {
+			out.WriteString("\n")
+		}
+		pendingBlank = false
+		out.WriteString(commandIndent + instruction.String())
+		if comment := command.comment; comment != "" {
+			out.WriteString(" " + strings.TrimSpace(comment))
+		}
+		out.WriteString("\n")
+	}
+	writeLines(trailing, indent, false)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,250 @@
+package virtualmachine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// An Op is the operation of a VM instruction.
+type Op int
+
+const (
+	OpAdd Op = iota + 1
+	OpSub
+	OpNeg
+	OpEq
+	OpGt
+	OpLt
+	OpAnd
+	OpOr
+	OpNot
+	OpPush
+	OpPop
+	OpLabel
+	OpGoto
+	OpIfGoto
+	OpFunction
+	OpCall
+	OpReturn
+)
+
+var opNames = map[Op]string{
+	OpAdd: "add", OpSub: "sub", OpNeg: "neg", OpEq: "eq", OpGt: "gt", OpLt: "lt", OpAnd: "and", OpOr: "or", OpNot: "not",
+	OpPush: "push", OpPop: "pop",
+	OpLabel: "label", OpGoto: "goto", OpIfGoto: "if-goto",
+	OpFunction: "function", OpCall: "call", OpReturn: "return",
+}
+
+var opsByName = func() map[string]Op {
+	ops := make(map[string]Op, len(opNames))
+	for op, name := range opNames {
+		ops[name] = op
+	}
+	return ops
+}()
+
+// String returns the VM mnemonic of the operation.
+func (op Op) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return "Op(" + strconv.Itoa(int(op)) + ")"
+}
+
+// A Segment is a virtual memory segment accessed by push and pop.
+type Segment int
+
+const (
+	SegmentNone Segment = iota
+	Argument
+	Local
+	Static
+	Constant
+	This
+	That
+	Pointer
+	Temp
+)
+
+var segmentNames = []string{"", "argument", "local", "static", "constant", "this", "that", "pointer", "temp"}
+
+// String returns the VM name of the segment.
+func (s Segment) String() string {
+	if s >= 0 && int(s) < len(segmentNames) {
+		return segmentNames[s]
+	}
+	return "Segment(" + strconv.Itoa(int(s)) + ")"
+}
+
+// segmentSizes bounds the indexes of the segments of fixed size.
+// Constants are pushed with an A-instruction, which holds at most 15 bits.
+var segmentSizes = map[Segment]int{Constant: 1 << 15, Pointer: 2, Temp: 8}
+
+// ParseSegment returns the segment with the given VM name.
+func ParseSegment(name string) (Segment, bool) {
+	for i, segmentName := range segmentNames {
+		if i > 0 && segmentName == name {
+			return Segment(i), true
+		}
+	}
+	return SegmentNone, false
+}
+
+// A Position locates a command in its VM file.
+type Position struct {
+	File string
+	Line int
+}
+
+// String formats the position as file:line.
+func (p Position) String() string {
+	return p.File + ":" + strconv.Itoa(p.Line)
+}
+
+// An Instruction is a parsed VM command, the in-memory representation shared by the translator and the tools built on it.
+type Instruction struct {
+	Op Op
+	// Segment is the segment accessed by push and pop.
+	Segment Segment
+	// Index is the segment index of push and pop, the number of locals of function, and the number of arguments of call.
+	Index int
+	// Label is the label of label, goto and if-goto, and the function name of function and call.
+	Label string
+	Pos   Position
+}
+
+// String returns the instruction in canonical VM syntax, e.g. "push local 3".
+func (i Instruction) String() string {
+	switch i.Op {
+	case OpPush, OpPop:
+		return i.Op.String() + " " + i.Segment.String() + " " + strconv.Itoa(i.Index)
+	case OpLabel, OpGoto, OpIfGoto:
+		return i.Op.String() + " " + i.Label
+	case OpFunction, OpCall:
+		return i.Op.String() + " " + i.Label + " " + strconv.Itoa(i.Index)
+	}
+	return i.Op.String()
+}
+
+// StackEffect returns the number of values the instruction pops from the working stack and pushes onto it.
+func (i Instruction) StackEffect() (pops, pushes int) {
+	switch i.Op {
+	case OpNeg, OpNot:
+		return 1, 1
+	case OpAdd, OpSub, OpEq, OpGt, OpLt, OpAnd, OpOr:
+		return 2, 1
+	case OpPush:
+		return 0, 1
+	case OpPop, OpIfGoto, OpReturn:
+		return 1, 0
+	case OpCall:
+		return i.Index, 1
+	}
+	return 0, 0
+}
+
+// A ParseError reports a malformed VM command.
+type ParseError struct {
+	Pos     Position
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return e.Pos.String() + ": " + e.Message
+}
+
+// ParseErrors lists the malformed commands of a file.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// ParseInstruction parses a single VM command, without comments, found at pos.
+func ParseInstruction(command string, pos Position) (Instruction, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return Instruction{}, &ParseError{pos, "empty command"}
+	}
+	op, ok := opsByName[fields[0]]
+	if !ok {
+		return Instruction{}, &ParseError{pos, fmt.Sprintf("unknown command %q", fields[0])}
+	}
+	instruction := Instruction{Op: op, Pos: pos}
+	arity := 0
+	switch op {
+	case OpLabel, OpGoto, OpIfGoto:
+		arity = 1
+	case OpPush, OpPop, OpFunction, OpCall:
+		arity = 2
+	}
+	if len(fields)-1 != arity {
+		return Instruction{}, &ParseError{pos, fmt.Sprintf("%s takes %d arguments, got %d", fields[0], arity, len(fields)-1)}
+	}
+	if arity == 2 {
+		index, err := strconv.Atoi(fields[2])
+		if err != nil || index < 0 {
+			return Instruction{}, &ParseError{pos, fmt.Sprintf("%s: %q is not a non-negative integer", fields[0], fields[2])}
+		}
+		instruction.Index = index
+	}
+	switch op {
+	case OpPush, OpPop:
+		if instruction.Segment, ok = ParseSegment(fields[1]); !ok {
+			return Instruction{}, &ParseError{pos, fmt.Sprintf("unknown segment %q", fields[1])}
+		}
+		if op == OpPop && instruction.Segment == Constant {
+			return Instruction{}, &ParseError{pos, "cannot pop to the constant segment"}
+		}
+		if size, bounded := segmentSizes[instruction.Segment]; bounded && instruction.Index >= size {
+			return Instruction{}, &ParseError{pos, fmt.Sprintf("%s index %d is out of range 0 to %d", fields[1], instruction.Index, size-1)}
+		}
+	case OpLabel, OpGoto, OpIfGoto, OpFunction, OpCall:
+		instruction.Label = fields[1]
+	}
+	return instruction, nil
+}
+
+// Parse reads the VM commands of the named file from input.
+// Malformed commands are left out of the returned instructions and reported together as ParseErrors.
+func Parse(filename string, input io.Reader) ([]Instruction, error) {
+	commands, _, err := readCommands(input)
+	if err != nil {
+		return nil, err
+	}
+	var instructions []Instruction
+	var errs ParseErrors
+	for _, command := range commands {
+		instruction, err := ParseInstruction(command.text, Position{File: filename, Line: command.line})
+		if err != nil {
+			var parseError *ParseError
+			if errors.As(err, &parseError) {
+				errs = append(errs, parseError)
+			}
+			continue
+		}
+		instructions = append(instructions, instruction)
+	}
+	if len(errs) > 0 {
+		return instructions, errs
+	}
+	return instructions, nil
+}
+
+// ParseFile reads the VM commands of a .vm file.
+func ParseFile(filename string) ([]Instruction, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(filename, f)
+}
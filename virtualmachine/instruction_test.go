@@ -0,0 +1,116 @@
+package virtualmachine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseInstruction(t *testing.T) {
+	tests := []struct {
+		command string
+		want    Instruction
+	}{
+		{"add", Instruction{Op: OpAdd}},
+		{"not", Instruction{Op: OpNot}},
+		{"push constant 32767", Instruction{Op: OpPush, Segment: Constant, Index: 32767}},
+		{"push  local   3", Instruction{Op: OpPush, Segment: Local, Index: 3}},
+		{"pop pointer 1", Instruction{Op: OpPop, Segment: Pointer, Index: 1}},
+		{"pop temp 7", Instruction{Op: OpPop, Segment: Temp, Index: 7}},
+		{"pop static 300", Instruction{Op: OpPop, Segment: Static, Index: 300}},
+		{"label LOOP.1", Instruction{Op: OpLabel, Label: "LOOP.1"}},
+		{"goto END", Instruction{Op: OpGoto, Label: "END"}},
+		{"if-goto END", Instruction{Op: OpIfGoto, Label: "END"}},
+		{"function Main.main 2", Instruction{Op: OpFunction, Label: "Main.main", Index: 2}},
+		{"call Math.multiply 2", Instruction{Op: OpCall, Label: "Math.multiply", Index: 2}},
+		{"return", Instruction{Op: OpReturn}},
+	}
+	pos := Position{File: "Main.vm", Line: 7}
+	for _, test := range tests {
+		test.want.Pos = pos
+		got, err := ParseInstruction(test.command, pos)
+		if err != nil {
+			t.Errorf("ParseInstruction(%q): %v", test.command, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseInstruction(%q) = %+v, want %+v", test.command, got, test.want)
+		}
+		if canonical := strings.Join(strings.Fields(test.command), " "); got.String() != canonical {
+			t.Errorf("ParseInstruction(%q).String() = %q, want %q", test.command, got.String(), canonical)
+		}
+	}
+}
+
+func TestParseInstructionErrors(t *testing.T) {
+	tests := []struct {
+		command, want string
+	}{
+		{"", "empty command"},
+		{"mul", `unknown command "mul"`},
+		{"Push constant 1", `unknown command "Push"`},
+		{"push heap 1", `unknown segment "heap"`},
+		{"pop Local 0", `unknown segment "Local"`},
+		{"push constant x", `push: "x" is not a non-negative integer`},
+		{"push local 1.5", `push: "1.5" is not a non-negative integer`},
+		{"push constant -1", `push: "-1" is not a non-negative integer`},
+		{"function Main.main -2", `function: "-2" is not a non-negative integer`},
+		{"call Math.multiply two", `call: "two" is not a non-negative integer`},
+		{"pop constant 0", "cannot pop to the constant segment"},
+		{"push constant 32768", "constant index 32768 is out of range 0 to 32767"},
+		{"push pointer 2", "pointer index 2 is out of range 0 to 1"},
+		{"pop temp 8", "temp index 8 is out of range 0 to 7"},
+		{"add 1", "add takes 0 arguments, got 1"},
+		{"return 0", "return takes 0 arguments, got 1"},
+		{"push constant", "push takes 2 arguments, got 1"},
+		{"pop local 0 1", "pop takes 2 arguments, got 3"},
+		{"label", "label takes 1 arguments, got 0"},
+		{"goto A B", "goto takes 1 arguments, got 2"},
+		{"if-goto", "if-goto takes 1 arguments, got 0"},
+		{"function Main.main", "function takes 2 arguments, got 1"},
+		{"call", "call takes 2 arguments, got 0"},
+	}
+	pos := Position{File: "Main.vm", Line: 7}
+	for _, test := range tests {
+		_, err := ParseInstruction(test.command, pos)
+		var parseError *ParseError
+		if !errors.As(err, &parseError) {
+			t.Errorf("ParseInstruction(%q) = %v, want a ParseError", test.command, err)
+			continue
+		}
+		if parseError.Pos != pos || parseError.Message != test.want {
+			t.Errorf("ParseInstruction(%q) = %v, want Main.vm:7: %s", test.command, err, test.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	source := `// Adds two numbers.
+push constant 1   // first
+  push constant 2
+
+add
+pop constant 0
+push nowhere 1
+`
+	instructions, err := Parse("Add.vm", strings.NewReader(source))
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Parse: %v, want 2 ParseErrors", err)
+	}
+	if want := "Add.vm:6: cannot pop to the constant segment\nAdd.vm:7: unknown segment \"nowhere\""; err.Error() != want {
+		t.Errorf("Parse errors\n%v\nwant\n%s", err, want)
+	}
+	var lines []int
+	var commands []string
+	for _, instruction := range instructions {
+		lines = append(lines, instruction.Pos.Line)
+		commands = append(commands, instruction.String())
+	}
+	if got, want := strings.Join(commands, "; "), "push constant 1; push constant 2; add"; got != want {
+		t.Errorf("Parse kept %q, want %q", got, want)
+	}
+	if len(lines) != 3 || lines[0] != 2 || lines[1] != 3 || lines[2] != 5 {
+		t.Errorf("Parse positioned the commands at lines %v, want [2 3 5]", lines)
+	}
+}
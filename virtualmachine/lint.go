@@ -0,0 +1,205 @@
+package virtualmachine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Diagnostic is a problem found in a VM file.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Message string
+}
+
+// String formats the diagnostic as file:line: message.
+func (d Diagnostic) String() string {
+	return d.File + ":" + strconv.Itoa(d.Line) + ": " + d.Message
+}
+
+// A Linter statically checks VM programs for stack imbalance, undefined labels and functions,
+// inconsistent calls, out of range or unused locals, missing returns and unreachable code.
+type Linter struct {
+	// Extern lists classes whose functions are assumed to be defined elsewhere, such as the Jack OS.
+	Extern []string
+
+	files       []string
+	functions   map[string]Diagnostic
+	calls       []lintCall
+	diagnostics []Diagnostic
+}
+
+// JackOSClasses are the classes of the Jack operating system, whose functions are available to every Jack program.
+var JackOSClasses = []string{"Array", "Keyboard", "Math", "Memory", "Output", "Screen", "String", "Sys"}
+
+type lintCall struct {
+	file     string
+	line     int
+	function string
+	numArgs  int
+}
+
+// NewLinter creates a linter for a program made of the files added to it.
+func NewLinter() *Linter {
+	return &Linter{functions: make(map[string]Diagnostic)}
+}
+
+// AddFile checks the functions of a .vm file.
+// Checks spanning several files are made by Diagnostics.
+func (l *Linter) AddFile(filename string, input io.Reader) {
+	l.files = append(l.files, filename)
+	instructions, err := Parse(filename, input)
+	var parseErrors ParseErrors
+	if errors.As(err, &parseErrors) {
+		for _, parseError := range parseErrors {
+			l.report(filename, parseError.Pos.Line, "%s", parseError.Message)
+		}
+	}
+	function, numLocals := "", -1
+	var body []Instruction
+	for _, instruction := range instructions {
+		switch instruction.Op {
+		case OpFunction:
+			l.checkFunction(filename, function, numLocals, body)
+			function, numLocals, body = instruction.Label, instruction.Index, nil
+			if previous, defined := l.functions[function]; defined {
+				l.report(filename, instruction.Pos.Line, "function %s already defined at %s:%d", function, previous.File, previous.Line)
+			} else {
+				l.functions[function] = Diagnostic{File: filename, Line: instruction.Pos.Line}
+			}
+		case OpCall:
+			l.calls = append(l.calls, lintCall{file: filename, line: instruction.Pos.Line, function: instruction.Label, numArgs: instruction.Index})
+		}
+		body = append(body, instruction)
+	}
+	l.checkFunction(filename, function, numLocals, body)
+}
+
+// checkFunction checks the body of a function, whose first command is its declaration.
+// Commands outside of any function have an empty name and no declared locals.
+func (l *Linter) checkFunction(filename, function string, numLocals int, body []Instruction) {
+	if len(body) == 0 {
+		return
+	}
+	labels := make(map[string]int)
+	usedLocals := make([]bool, max(numLocals, 0))
+	for i, command := range body {
+		switch command.Op {
+		case OpLabel:
+			if previous, defined := labels[command.Label]; defined {
+				l.report(filename, command.Pos.Line, "label %s already defined at line %d", command.Label, body[previous].Pos.Line)
+			} else {
+				labels[command.Label] = i
+			}
+		case OpPush, OpPop:
+			if command.Segment == Local && numLocals >= 0 && command.Index >= numLocals {
+				l.report(filename, command.Pos.Line, "%s: %s declares only %d locals", command, function, numLocals)
+			} else if command.Segment == Local && numLocals >= 0 {
+				usedLocals[command.Index] = true
+			}
+		}
+	}
+	for i, used := range usedLocals {
+		if !used {
+			l.report(filename, body[0].Pos.Line, "function %s never uses local %d", function, i)
+		}
+	}
+	for _, command := range body {
+		if command.Op == OpGoto || command.Op == OpIfGoto {
+			if _, defined := labels[command.Label]; !defined {
+				l.report(filename, command.Pos.Line, "%s: undefined label %s", command, command.Label)
+			}
+		}
+	}
+
+	// Follow every path from the start of the function, tracking the depth of its working stack.
+	depths := make([]int, len(body))
+	visited := make([]bool, len(body))
+	type state struct{ index, depth int }
+	pending := []state{{0, 0}}
+	fallsThrough := false
+	for len(pending) > 0 {
+		s := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if s.index == len(body) {
+			fallsThrough = true
+			continue
+		}
+		command := body[s.index]
+		if visited[s.index] {
+			if depths[s.index] != s.depth {
+				l.report(filename, command.Pos.Line, "%s: stack depth is %d on one path and %d on another", command, depths[s.index], s.depth)
+			}
+			continue
+		}
+		visited[s.index], depths[s.index] = true, s.depth
+		pops, pushes := command.StackEffect()
+		if pops > s.depth {
+			l.report(filename, command.Pos.Line, "%s: stack underflow, needs %d values but has %d", command, pops, s.depth)
+			pops = s.depth
+		}
+		depth := s.depth - pops + pushes
+		switch command.Op {
+		case OpReturn:
+			// An empty stack is reported as an underflow.
+			if function != "" && s.depth > 1 {
+				l.report(filename, command.Pos.Line, "%s: stack depth is %d, but a function returns a single value", command, s.depth)
+			}
+		case OpGoto:
+			if target, defined := labels[command.Label]; defined {
+				pending = append(pending, state{target, depth})
+			}
+		case OpIfGoto:
+			if target, defined := labels[command.Label]; defined {
+				pending = append(pending, state{target, depth})
+			}
+			pending = append(pending, state{s.index + 1, depth})
+		default:
+			pending = append(pending, state{s.index + 1, depth})
+		}
+	}
+	if fallsThrough && function != "" {
+		l.report(filename, body[len(body)-1].Pos.Line, "function %s can end without return", function)
+	}
+	for i, command := range body {
+		if !visited[i] && (i == 0 || visited[i-1]) {
+			l.report(filename, command.Pos.Line, "unreachable code: %s", command)
+		}
+	}
+}
+
+// Diagnostics checks the calls between the added files and returns all problems found, ordered by file and line.
+func (l *Linter) Diagnostics() []Diagnostic {
+	numArgs := make(map[string]lintCall)
+	for _, call := range l.calls {
+		class, _, _ := strings.Cut(call.function, ".")
+		if _, defined := l.functions[call.function]; !defined && !slices.Contains(l.Extern, class) {
+			l.report(call.file, call.line, "call to undefined function %s", call.function)
+		}
+		if first, called := numArgs[call.function]; !called {
+			numArgs[call.function] = call
+		} else if first.numArgs != call.numArgs {
+			l.report(call.file, call.line, "%s called with %d arguments, but with %d at %s:%d", call.function, call.numArgs, first.numArgs, first.file, first.line)
+		}
+	}
+	l.calls = nil
+
+	diagnostics := slices.Clone(l.diagnostics)
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		fi, fj := slices.Index(l.files, diagnostics[i].File), slices.Index(l.files, diagnostics[j].File)
+		if fi != fj {
+			return fi < fj
+		}
+		return diagnostics[i].Line < diagnostics[j].Line
+	})
+	return diagnostics
+}
+
+func (l *Linter) report(filename string, line int, format string, args ...any) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{File: filename, Line: line, Message: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,174 @@
+package virtualmachine
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// lint checks the sources of files, added in order, and returns the diagnostics found.
+func lint(t *testing.T, extern []string, files ...string) []string {
+	t.Helper()
+	linter := NewLinter()
+	linter.Extern = extern
+	for i, source := range files {
+		linter.AddFile(string(rune('A'+i))+".vm", strings.NewReader(source))
+	}
+	var diagnostics []string
+	for _, diagnostic := range linter.Diagnostics() {
+		diagnostics = append(diagnostics, diagnostic.String())
+	}
+	return diagnostics
+}
+
+func TestLinter(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{
+			name: "no problems",
+			files: []string{`
+function A.main 1
+push constant 1
+pop local 0
+push local 0
+if-goto DONE
+call A.f 0
+pop temp 0
+label DONE
+push constant 0
+return
+function A.f 0
+push constant 2
+return`},
+		},
+		{
+			name:  "undefined label",
+			files: []string{"function A.f 0\npush constant 0\nif-goto END\npush constant 0\nreturn\n"},
+			want:  []string{"A.vm:3: if-goto END: undefined label END"},
+		},
+		{
+			name:  "duplicate label",
+			files: []string{"function A.f 0\nlabel L\nlabel L\npush constant 0\nreturn\n"},
+			want:  []string{"A.vm:3: label L already defined at line 2"},
+		},
+		{
+			name:  "local beyond those declared",
+			files: []string{"function A.f 1\npush local 0\npop local 1\npush constant 0\nreturn\n"},
+			want:  []string{"A.vm:3: pop local 1: A.f declares only 1 locals"},
+		},
+		{
+			name:  "unused local",
+			files: []string{"function A.f 3\npush local 0\npop local 2\npush constant 0\nreturn\n"},
+			want:  []string{"A.vm:1: function A.f never uses local 1"},
+		},
+		{
+			name:  "missing return",
+			files: []string{"function A.f 0\npush constant 0\npop temp 0\n"},
+			want:  []string{"A.vm:3: function A.f can end without return"},
+		},
+		{
+			name:  "missing return on one path",
+			files: []string{"function A.f 0\npush argument 0\nif-goto END\npush constant 0\nreturn\nlabel END\n"},
+			want:  []string{"A.vm:6: function A.f can end without return"},
+		},
+		{
+			name:  "unreachable code",
+			files: []string{"function A.f 0\npush constant 0\nreturn\npush constant 1\nreturn\n"},
+			want:  []string{"A.vm:4: unreachable code: push constant 1"},
+		},
+		{
+			name:  "call to an undefined function",
+			files: []string{"function A.f 0\ncall A.g 0\nreturn\n"},
+			want:  []string{"A.vm:2: call to undefined function A.g"},
+		},
+		{
+			name:  "call to an extern function",
+			files: []string{"function A.f 0\ncall Math.multiply 0\nreturn\n"},
+		},
+		{
+			name: "wrong number of arguments",
+			files: []string{
+				"function A.f 0\npush constant 1\ncall B.g 1\nreturn\n",
+				"function B.g 0\npush constant 1\npush constant 2\ncall B.g 2\nreturn\n",
+			},
+			want: []string{"B.vm:4: B.g called with 2 arguments, but with 1 at A.vm:3"},
+		},
+		{
+			name:  "duplicate function",
+			files: []string{"function A.f 0\npush constant 0\nreturn\n", "function A.f 0\npush constant 0\nreturn\n"},
+			want:  []string{"B.vm:1: function A.f already defined at A.vm:1"},
+		},
+		{
+			name:  "return with several values on the stack",
+			files: []string{"function A.f 0\npush constant 1\npush constant 2\nreturn\n"},
+			want:  []string{"A.vm:4: return: stack depth is 2, but a function returns a single value"},
+		},
+		{
+			name:  "return with an empty stack",
+			files: []string{"function A.f 0\nreturn\n"},
+			want:  []string{"A.vm:2: return: stack underflow, needs 1 values but has 0"},
+		},
+		{
+			name:  "stack underflow",
+			files: []string{"function A.f 0\npush constant 1\nadd\nreturn\n"},
+			want:  []string{"A.vm:3: add: stack underflow, needs 2 values but has 1"},
+		},
+		{
+			name:  "stack depths differ between paths",
+			files: []string{"function A.f 0\npush argument 0\nif-goto L\npush constant 1\nlabel L\npush constant 2\nreturn\n"},
+			want: []string{
+				"A.vm:5: label L: stack depth is 1 on one path and 0 on another",
+				"A.vm:7: return: stack depth is 2, but a function returns a single value",
+			},
+		},
+		{
+			name:  "malformed command",
+			files: []string{"function A.f 0\npush nowhere 1\npush constant 0\nreturn\n"},
+			want:  []string{`A.vm:2: unknown segment "nowhere"`},
+		},
+	}
+	for _, test := range tests {
+		got := lint(t, JackOSClasses, test.files...)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: diagnostics\n%s\nwant\n%s", test.name, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+// TestLintCoursePrograms checks that the programs of projects 7 and 8, which are correct, have no diagnostics.
+func TestLintCoursePrograms(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("..", "[78]", "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	linted := 0
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.vm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			continue
+		}
+		linter := NewLinter()
+		for _, file := range files {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			linter.AddFile(file, strings.NewReader(string(source)))
+		}
+		for _, diagnostic := range linter.Diagnostics() {
+			t.Errorf("%s", diagnostic)
+		}
+		linted++
+	}
+	if linted < 11 {
+		t.Errorf("linted %d programs of projects 7 and 8, want 11", linted)
+	}
+}
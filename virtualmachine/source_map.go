@@ -0,0 +1,63 @@
+package virtualmachine
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// A SourceMapping relates a block of generated assembly to the VM command it was translated from.
+// The block extends up to the next mapping in the SourceMap.
+type SourceMapping struct {
+	// AsmLine is the 1-based line of the .asm output at which the block starts.
+	AsmLine int `json:"asmLine"`
+	// ROMAddress is the address of the first instruction of the block once assembled.
+	ROMAddress int    `json:"romAddress"`
+	File       string `json:"file,omitempty"`
+	Line       int    `json:"line,omitempty"`
+	Function   string `json:"function,omitempty"`
+	Command    string `json:"command"`
+}
+
+// A SourceMap lists the mappings of a translated program in output order.
+// It lets emulators and debuggers present Hack execution in terms of the VM source.
+type SourceMap struct {
+	Mappings []SourceMapping `json:"mappings"`
+}
+
+// Lookup returns the mapping of the block containing the instruction at romAddress.
+func (sm *SourceMap) Lookup(romAddress int) (SourceMapping, bool) {
+	// Mappings are in ROM address order. Blocks of commands emitting no instructions share their address
+	// with the next block, so the last mapping at or before romAddress wins.
+	found := sort.Search(len(sm.Mappings), func(i int) bool { return sm.Mappings[i].ROMAddress > romAddress }) - 1
+	if found < 0 {
+		return SourceMapping{}, false
+	}
+	return sm.Mappings[found], true
+}
+
+// Append adds the mappings of other, whose assembly was written after asmLines lines
+// and romAddresses instructions of the output described by sm.
+func (sm *SourceMap) Append(other *SourceMap, asmLines, romAddresses int) {
+	for _, m := range other.Mappings {
+		m.AsmLine += asmLines
+		m.ROMAddress += romAddresses
+		sm.Mappings = append(sm.Mappings, m)
+	}
+}
+
+// WriteSourceMap encodes sm as JSON to w.
+func WriteSourceMap(w io.Writer, sm *SourceMap) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sm)
+}
+
+// ReadSourceMap decodes a JSON source map written by WriteSourceMap.
+func ReadSourceMap(r io.Reader) (*SourceMap, error) {
+	var sm SourceMap
+	if err := json.NewDecoder(r).Decode(&sm); err != nil {
+		return nil, err
+	}
+	return &sm, nil
+}
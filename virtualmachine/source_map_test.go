@@ -0,0 +1,35 @@
+package virtualmachine
+
+import "testing"
+
+func TestSourceMapLookup(t *testing.T) {
+	sm := &SourceMap{Mappings: []SourceMapping{
+		{ROMAddress: 0, Command: "bootstrap"},
+		{ROMAddress: 10, Command: "label LOOP"},
+		{ROMAddress: 10, Command: "push constant 1"},
+		{ROMAddress: 17, Command: "return"},
+	}}
+	tests := []struct {
+		address int
+		want    string
+		found   bool
+	}{
+		{-1, "", false},
+		{0, "bootstrap", true},
+		{9, "bootstrap", true},
+		// A label emits no instructions, so its address belongs to the next command.
+		{10, "push constant 1", true},
+		{16, "push constant 1", true},
+		{17, "return", true},
+		{1000, "return", true},
+	}
+	for _, test := range tests {
+		m, found := sm.Lookup(test.address)
+		if found != test.found || m.Command != test.want {
+			t.Errorf("Lookup(%d) = %q, %v, want %q, %v", test.address, m.Command, found, test.want, test.found)
+		}
+	}
+	if _, found := (&SourceMap{}).Lookup(0); found {
+		t.Errorf("Lookup in an empty source map found a mapping")
+	}
+}